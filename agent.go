@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/ngrash/optask/internal/agent"
+	"github.com/ngrash/optask/internal/model"
+)
+
+func agentCmd(args []string) int {
+	fs, client := clientFlags("agent", "[-label key=value ...]")
+	labels := params{}
+	fs.Var(labels, "label", "advertise a label matched against the targets of tasks (repeatable)")
+	parseArgs(fs, args, 0)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	(&agent.Agent{Client: client(), Labels: labels}).Run(ctx)
+	return 0
+}
+
+func agentsCmd(args []string) int {
+	fs, client := clientFlags("agents", "")
+	parseArgs(fs, args, 0)
+
+	agents, err := client().Agents()
+	if err != nil {
+		return fail(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "AGENT\tSTATE\tSINCE\tRUNS\tLABELS")
+	for _, a := range agents {
+		state, since := "disconnected", "-"
+		if a.Connected {
+			state = "connected"
+		}
+		if !a.Since.IsZero() {
+			since = a.Since.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", a.Name, state, since, a.Runs, model.FormatLabels(a.Labels))
+	}
+	w.Flush()
+	return 0
+}
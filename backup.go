@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/ngrash/optask/internal/config"
+	"github.com/ngrash/optask/internal/db"
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/runner"
+)
+
+// backupCmd downloads a copy of the database from a running server.
+func backupCmd(args []string) int {
+	fs, client := clientFlags("backup", "[-format db|jsonl] <file>")
+	format := fs.String("format", "db", "db for a copy of the database file, jsonl for the portable format read by import")
+	pos := parseArgs(fs, args, 1)
+
+	f, err := os.Create(pos[0])
+	if err != nil {
+		return fail(err)
+	}
+
+	err = client().Backup(*format, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(pos[0])
+		return fail(err)
+	}
+	return 0
+}
+
+// dataFlags creates a FlagSet with the flags shared by all commands working on the database
+// directly. These commands must not run while a server uses the database.
+func dataFlags(name, usage string) (*flag.FlagSet, func() (*model.Project, string, string)) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: optask %v %v\n", name, usage)
+		fs.PrintDefaults()
+	}
+
+	configPath := fs.String("config", envOr("OPTASK_CONFIG", "config.json"), "project configuration file (env OPTASK_CONFIG)")
+	dataDir := fs.String("data", envOr("OPTASK_DATA", "data"), "directory for the run database (env OPTASK_DATA)")
+	storage := fs.String("storage", envOr("OPTASK_STORAGE", db.Bolt), "storage backend of the run database, bolt or sqlite (env OPTASK_STORAGE)")
+
+	return fs, func() (*model.Project, string, string) {
+		p, err := config.Read(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "optask: reading config:\n%v\n", err)
+			os.Exit(1)
+		}
+		if err := os.MkdirAll(*dataDir, runner.DataDirPerm); err != nil {
+			os.Exit(fail(err))
+		}
+		return p, *storage, runner.DatabaseFile(p, *dataDir, *storage)
+	}
+}
+
+// auditLocal records an action of the local user in the audit log of store.
+func auditLocal(store db.Store, action, detail string) error {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return store.AppendAudit(&model.AuditEntry{Time: time.Now(), User: name, RemoteAddr: "local", Action: action, Detail: detail})
+}
+
+// restoreCmd replaces the database with a copy written by backup -format db.
+func restoreCmd(args []string) int {
+	fs, project := dataFlags("restore", "<file>")
+	pos := parseArgs(fs, args, 1)
+
+	f, err := os.Open(pos[0])
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+
+	p, storage, file := project()
+	if err := db.Restore(storage, file, f); err != nil {
+		return fail(err)
+	}
+
+	a, err := db.Open(storage, file, p)
+	if err != nil {
+		return fail(err)
+	}
+	defer a.Close()
+	if err := auditLocal(a, model.AuditRestore, pos[0]); err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("restored %v\n", file)
+	return 0
+}
+
+// importCmd adds runs written by backup -format jsonl to the database.
+func importCmd(args []string) int {
+	fs, project := dataFlags("import", "<file>")
+	pos := parseArgs(fs, args, 1)
+
+	f, err := os.Open(pos[0])
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+
+	p, storage, file := project()
+	a, err := db.Open(storage, file, p)
+	if err != nil {
+		return fail(err)
+	}
+	defer a.Close()
+
+	n, err := a.Import(f)
+	if err != nil {
+		return fail(err)
+	}
+	if err := auditLocal(a, model.AuditImport, fmt.Sprintf("%v runs from %v", n, pos[0])); err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("imported %v runs into %v\n", n, file)
+	return 0
+}
+
+// migrateCmd updates the schema of the database, which otherwise happens when the server
+// starts.
+func migrateCmd(args []string) int {
+	fs, project := dataFlags("migrate", "[-dry-run] [-backup=false]")
+	dryRun := fs.Bool("dry-run", false, "list pending migrations without applying them")
+	backup := fs.Bool("backup", true, "copy the database to <file>.v<version>.bak before migrating")
+	parseArgs(fs, args, 0)
+
+	_, storage, file := project()
+	migrations, err := db.Migrate(storage, file, db.MigrateOptions{DryRun: *dryRun, Backup: *backup, Logf: log.Printf})
+	if err != nil {
+		return fail(err)
+	}
+
+	if len(migrations) == 0 {
+		fmt.Printf("%v is up to date\n", file)
+	} else if *dryRun {
+		fmt.Printf("pending migrations of %v:\n", file)
+		for _, m := range migrations {
+			fmt.Printf("  %v\n", m)
+		}
+	}
+	return 0
+}
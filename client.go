@@ -0,0 +1,323 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ngrash/optask/internal/api"
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+const pollInterval = 500 * time.Millisecond // interval for polling the output of running runs
+
+// clientFlags creates a FlagSet with the flags shared by all commands talking to a server.
+func clientFlags(name, usage string) (*flag.FlagSet, func() *api.Client) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: optask %v %v\n", name, usage)
+		fs.PrintDefaults()
+	}
+
+	server := fs.String("server", envOr("OPTASK_SERVER", "http://localhost:8080"), "URL of the optask server (env OPTASK_SERVER)")
+	token := fs.String("token", os.Getenv("OPTASK_TOKEN"), "API token (env OPTASK_TOKEN)")
+
+	return fs, func() *api.Client {
+		return api.NewClient(*server, *token)
+	}
+}
+
+// parseArgs parses flags that may appear before, between or after positional arguments and
+// exits with a usage message unless exactly n positional arguments are given.
+func parseArgs(fs *flag.FlagSet, args []string, n int) []string {
+	var pos []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		pos = append(pos, args[0])
+		args = args[1:]
+	}
+
+	if len(pos) != n {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	return pos
+}
+
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// params collects repeated -param k=v flags.
+type params map[string]string
+
+func (p params) String() string {
+	return fmt.Sprint(map[string]string(p))
+}
+
+func (p params) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	p[kv[0]] = kv[1]
+	return nil
+}
+
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "optask: %v\n", err)
+	return 1
+}
+
+func runCmd(args []string) int {
+	fs, client := clientFlags("run", "[-param key=value ...] [-wait] [-override] <task>")
+	ps := make(params)
+	fs.Var(ps, "param", "parameter passed to the task, may be repeated")
+	wait := fs.Bool("wait", false, "print the output and exit with the exit code of the run, 0 if it succeeded")
+	override := fs.Bool("override", false, "start the run even if the task is blocked by a freeze or its windows")
+	pos := parseArgs(fs, args, 1)
+
+	c := client()
+	res, err := c.Exec(model.TaskID(pos[0]), ps, *override)
+	if err != nil {
+		return fail(err)
+	}
+
+	if res.Request != nil {
+		fmt.Fprintf(os.Stderr, "optask: run waits for approval by another user until %v\n", res.Request.Expires.Format("2006-01-02 15:04:05"))
+		fmt.Println(res.Request.ID)
+		if *wait {
+			return 1 // there is no run to wait for yet
+		}
+		return 0
+	}
+
+	if !*wait {
+		fmt.Println(res.RunID)
+		return 0
+	}
+
+	return tail(c, res.TaskID, res.RunID, false)
+}
+
+func tailCmd(args []string) int {
+	fs, client := clientFlags("tail", "<task> <run>")
+	prefix := fs.Bool("prefix", false, "prefix each line with the name of its stream")
+	pos := parseArgs(fs, args, 2)
+
+	return tail(client(), model.TaskID(pos[0]), model.RunID(pos[1]), *prefix)
+}
+
+// tail prints the output of a run until it completed and returns its exit code, or 0 if it
+// succeeded. Lines written to stdout are printed to stdout, lines written to stderr are printed
+// to stderr.
+func tail(c *api.Client, tID model.TaskID, rID model.RunID, prefix bool) int {
+	skip := 0
+	for {
+		lines, running, err := c.Lines(tID, rID, skip)
+		if err != nil {
+			return fail(err)
+		}
+
+		for _, l := range lines {
+			var w io.Writer = os.Stdout
+			p := "out: "
+			if l.Stream == stdstreams.Err {
+				w, p = os.Stderr, "err: "
+			}
+			if !prefix {
+				p = ""
+			}
+			fmt.Fprintf(w, "%v%v\n", p, l.Text)
+		}
+		skip += len(lines)
+
+		if !running {
+			break
+		}
+		time.Sleep(pollInterval)
+	}
+
+	r, err := c.Run(tID, rID)
+	if err != nil {
+		return fail(err)
+	}
+
+	if r.Canceled {
+		fmt.Fprintln(os.Stderr, "optask: run was canceled")
+	}
+	if r.Error != "" {
+		fmt.Fprintf(os.Stderr, "optask: run failed: %v\n", r.Error)
+	}
+	switch {
+	case r.Outcome.OK():
+		return 0 // the success rules of the task may accept other exit codes
+	case r.ExitCode <= 0:
+		return 1 // killed by a signal or failed by a success rule
+	}
+	return r.ExitCode
+}
+
+func historyCmd(args []string) int {
+	fs, client := clientFlags("history", "[-n count] <task>")
+	count := fs.Int("n", 20, "number of runs to list")
+	pos := parseArgs(fs, args, 1)
+
+	runs, err := client().History(model.TaskID(pos[0]), "", *count)
+	if err != nil {
+		return fail(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSTATUS\tSTARTED\tDURATION\tEXIT\tSUMMARY")
+	for _, r := range runs {
+		printRun(w, r)
+	}
+	w.Flush()
+	return 0
+}
+
+func statusCmd(args []string) int {
+	fs, client := clientFlags("status", "[-tag tag]")
+	tag := fs.String("tag", "", "list only tasks with this tag")
+	parseArgs(fs, args, 0)
+
+	c := client()
+	tasks, err := c.Status(*tag)
+	if err != nil {
+		return fail(err)
+	}
+	f, err := c.Frozen()
+	if err != nil {
+		return fail(err)
+	}
+	if f != nil {
+		fmt.Fprintf(os.Stderr, "optask: frozen since %v by %v: %v\n", f.Since.Format("2006-01-02 15:04:05"), f.User, f.Reason)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tRUN\tSTATUS\tSTARTED\tDURATION\tEXIT\tSUMMARY")
+	for _, t := range tasks {
+		fmt.Fprintf(w, "%v\t", t.ID)
+		if t.LastRun == nil {
+			fmt.Fprintln(w, "-\tnever ran\t\t\t\t")
+			continue
+		}
+		printRun(w, t.LastRun)
+	}
+	w.Flush()
+	return 0
+}
+
+func cancelCmd(args []string) int {
+	fs, client := clientFlags("cancel", "<task> <run>")
+	pos := parseArgs(fs, args, 2)
+
+	if err := client().Cancel(model.TaskID(pos[0]), model.RunID(pos[1])); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func requestsCmd(args []string) int {
+	fs, client := clientFlags("requests", "")
+	parseArgs(fs, args, 0)
+
+	reqs, err := client().Requests()
+	if err != nil {
+		return fail(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REQUEST\tTASK\tUSER\tEXPIRES\tPARAMETERS")
+	for _, req := range reqs {
+		var ps []string
+		for k, v := range req.Params {
+			ps = append(ps, k+"="+v)
+		}
+		sort.Strings(ps)
+		expires := req.Expires.Format("2006-01-02 15:04:05")
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\n", req.ID, req.TaskID, req.User, expires, strings.Join(ps, " "))
+	}
+	w.Flush()
+	return 0
+}
+
+func approveCmd(args []string) int {
+	fs, client := clientFlags("approve", "[-wait] [-override] <request>")
+	wait := fs.Bool("wait", false, "print the output and exit with the exit code of the run, 0 if it succeeded")
+	override := fs.Bool("override", false, "start the run even if the task is blocked by a freeze or its windows")
+	pos := parseArgs(fs, args, 1)
+
+	c := client()
+	res, err := c.Approve(pos[0], *override)
+	if err != nil {
+		return fail(err)
+	}
+
+	if !*wait {
+		fmt.Println(res.RunID)
+		return 0
+	}
+
+	return tail(c, res.TaskID, res.RunID, false)
+}
+
+func rejectCmd(args []string) int {
+	fs, client := clientFlags("reject", "<request>")
+	pos := parseArgs(fs, args, 1)
+
+	if err := client().Reject(pos[0]); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func freezeCmd(args []string) int {
+	fs, client := clientFlags("freeze", "<reason>")
+	pos := parseArgs(fs, args, 1)
+
+	if err := client().Freeze(pos[0]); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func unfreezeCmd(args []string) int {
+	fs, client := clientFlags("unfreeze", "")
+	parseArgs(fs, args, 0)
+
+	if err := client().Unfreeze(); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func printRun(w io.Writer, r *api.Run) {
+	status, exit, d := "running", "-", time.Since(r.Started)
+	if !r.Running {
+		status, exit, d = string(r.Outcome), fmt.Sprint(r.ExitCode), r.Completed.Sub(r.Started)
+	}
+
+	summary := r.Summary
+	if r.Error != "" {
+		summary = r.Error
+	}
+
+	started := r.Started.Format("2006-01-02 15:04:05")
+	fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", r.ID, status, started, d.Truncate(time.Second), exit, summary)
+}
@@ -0,0 +1,225 @@
+// Package agent implements the agent mode of optask: agents connect to a server, advertise
+// labels and run the tasks the server assigns to them, see model.Task.Target.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ngrash/optask/internal/api"
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+	"github.com/ngrash/optask/internal/usage"
+)
+
+const (
+	reconnectMin    = time.Second      // delay before reconnecting after the connection failed
+	reconnectMax    = 30 * time.Second // delay after repeated failures
+	pingTimeout     = 3 * api.AgentPingInterval
+	reportInterval  = 250 * time.Millisecond // time between reports of new output
+	cancelWaitDelay = 10 * time.Second       // time between SIGTERM and SIGKILL when canceling a run
+)
+
+type runKey struct {
+	task model.TaskID
+	run  model.RunID
+}
+
+// Agent runs the tasks assigned by a server.
+type Agent struct {
+	Client *api.Client // authenticated with the token of a model.Agent
+	Labels map[string]string
+
+	mu   sync.Mutex
+	runs map[runKey]context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// Run connects to the server and runs the assigned tasks until ctx is done, reconnecting when
+// the connection fails. Runs are canceled when ctx is done and reported before Run returns.
+func (a *Agent) Run(ctx context.Context) {
+	a.runs = make(map[runKey]context.CancelFunc)
+	defer a.wg.Wait()
+
+	delay := reconnectMin
+	for {
+		connected, err := a.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = reconnectMin
+		}
+		log.Printf("disconnected from %v: %v, reconnecting in %v", a.Client.BaseURL, err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(2*delay, reconnectMax)
+	}
+}
+
+// connect handles a single connection to the server. It reports whether the connection was
+// established.
+func (a *Agent) connect(ctx context.Context) (bool, error) {
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := a.Client.ConnectAgent(connCtx, a.Labels)
+	if err != nil {
+		return false, err
+	}
+	defer stream.Close()
+	log.Printf("connected to %v with labels %v", a.Client.BaseURL, model.FormatLabels(a.Labels))
+
+	timeout := time.AfterFunc(pingTimeout, cancel) // the server went away silently
+	defer timeout.Stop()
+	for {
+		m, err := stream.Next()
+		if err != nil {
+			if connCtx.Err() != nil && ctx.Err() == nil {
+				err = fmt.Errorf("no message for %v", pingTimeout)
+			}
+			return true, err
+		}
+		timeout.Reset(pingTimeout)
+
+		switch {
+		case m.Run != nil:
+			a.start(ctx, *m.Run)
+		case m.Cancel != nil:
+			a.cancel(runKey{m.Cancel.TaskID, m.Cancel.RunID})
+		}
+	}
+}
+
+// start starts a run in the background.
+func (a *Agent) start(ctx context.Context, run api.AgentRun) {
+	key := runKey{run.TaskID, run.RunID}
+	runCtx, cancel := context.WithCancel(ctx)
+	a.mu.Lock()
+	a.runs[key] = cancel
+	a.mu.Unlock()
+
+	log.Printf("starting run %v of %v", run.RunID, run.TaskID)
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if err := execute(ctx, runCtx, cancel, a.Client, run); err != nil {
+			log.Printf("run %v of %v: %v", run.RunID, run.TaskID, err)
+		}
+
+		a.mu.Lock()
+		delete(a.runs, key)
+		a.mu.Unlock()
+		cancel()
+	}()
+}
+
+func (a *Agent) cancel(key runKey) {
+	a.mu.Lock()
+	cancel := a.runs[key]
+	a.mu.Unlock()
+
+	if cancel != nil {
+		log.Printf("canceling run %v of %v", key.run, key.task)
+		cancel()
+	}
+}
+
+// execute runs the process of run with ctx and streams its output and result to the server.
+// The process is canceled if the server rejects the reports. The run fails with an error if
+// agentCtx is done before the process exits, i.e. the agent stopped.
+func execute(agentCtx, ctx context.Context, cancel context.CancelFunc, client *api.Client, run api.AgentRun) error {
+	pr, pw := io.Pipe()
+	reported := make(chan error, 1)
+	go func() {
+		// not canceled with ctx, so the result of canceled runs is reported
+		err := client.ReportRun(context.Background(), run.TaskID, run.RunID, pr)
+		pr.CloseWithError(err)
+		reported <- err
+	}()
+
+	l := stdstreams.NewLog()
+	cmd := exec.CommandContext(ctx, run.Cmd, run.Args...)
+	cmd.Dir = run.Dir
+	cmd.Env = append(os.Environ(), run.Env...)
+	cmd.Stdin = strings.NewReader(run.Stdin)
+	cmd.Stdout = l.Stdout()
+	cmd.Stderr = l.Stderr()
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = cancelWaitDelay
+
+	enc := json.NewEncoder(pw)
+	sent := 0
+	report := func() error {
+		lines := l.Lines()
+		for ; sent < len(lines); sent++ {
+			if err := enc.Encode(api.AgentReport{Line: &lines[sent]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var reportErr error
+	done := api.AgentDone{ExitCode: -1}
+	if err := cmd.Start(); err != nil {
+		done.Error = fmt.Sprintf("starting process: %v", err)
+	} else {
+		waited := make(chan error, 1)
+		go func() { waited <- cmd.Wait() }()
+
+		tick := time.NewTicker(reportInterval)
+	wait:
+		for {
+			select {
+			case err := <-waited:
+				if _, ok := err.(*exec.ExitError); !ok && err != nil {
+					done.Error = fmt.Sprintf("waiting for process: %v", err)
+				}
+				if agentCtx.Err() != nil {
+					done.Error = "agent stopped during the run"
+				}
+				break wait
+			case <-tick.C:
+				if reportErr == nil {
+					if reportErr = report(); reportErr != nil {
+						cancel() // nobody sees the output
+					}
+				}
+			}
+		}
+		tick.Stop()
+
+		ps := cmd.ProcessState
+		done.ExitCode = ps.ExitCode()
+		done.CPUTime = usage.CPUTime(ps)
+		done.MaxRSS = usage.MaxRSS(ps)
+	}
+
+	l.Flush()
+	if reportErr == nil {
+		reportErr = report()
+	}
+	if reportErr == nil {
+		reportErr = enc.Encode(api.AgentReport{Done: &done})
+	}
+	pw.CloseWithError(reportErr)
+
+	if err := <-reported; err != nil {
+		return err
+	}
+	return reportErr
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ngrash/optask/internal/api"
+	"github.com/ngrash/optask/internal/db"
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/runner"
+	"github.com/ngrash/optask/internal/web"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %v", what)
+		}
+	}
+}
+
+// TestAgents runs a server and two agents on localhost.
+func TestAgents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "optask-agent-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := map[string]string{"role": "db"}
+	p := &model.Project{
+		ID:   "p",
+		Name: "P",
+		Tasks: []model.Task{
+			{ID: "hello", Name: "Hello", Cmd: "/bin/sh", Args: []string{"-c", "echo hi $OPTASK_PARAM_X; sleep 0.2"}, Target: target},
+			{ID: "long", Name: "Long", Cmd: "/bin/sh", Args: []string{"-c", "trap 'exit 5' TERM; echo start; while :; do sleep 0.05; done"}, Target: target},
+			{ID: "missing", Name: "Missing", Cmd: "/no/such/command", Target: target},
+		},
+		Users:  []model.User{{Name: "alice", Token: "u1"}},
+		Agents: []model.Agent{{Name: "db1", Token: "a1"}, {Name: "db2", Token: "a2"}},
+	}
+	svc := runner.NewService(p, dir, db.Bolt)
+	srv, err := web.NewServer(p, svc, web.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	if _, err := api.NewClient(ts.URL, "u1").ConnectAgent(context.Background(), nil); err == nil {
+		t.Error("Expected an error connecting with the token of a user")
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	stopped := make(chan struct{}, 2)
+	for _, token := range []string{"a1", "a2"} {
+		a := &Agent{Client: api.NewClient(ts.URL, token), Labels: target}
+		go func() {
+			a.Run(ctx)
+			stopped <- struct{}{}
+		}()
+	}
+	defer func() {
+		stop()
+		<-stopped
+		<-stopped
+	}()
+
+	connected := func(name string) bool {
+		for _, a := range svc.Agents() {
+			if a.Name == name {
+				return a.Connected && a.Labels["role"] == "db"
+			}
+		}
+		return false
+	}
+	waitFor(t, "agents to connect", func() bool { return connected("db1") && connected("db2") })
+
+	completed := func(tID model.TaskID, rID model.RunID) *model.Run {
+		t.Helper()
+		waitFor(t, "run "+string(rID)+" of "+string(tID), func() bool { return !svc.IsRunning(tID, rID) })
+		r, err := svc.Run(tID, rID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	t.Run("Balance", func(t *testing.T) {
+		r1, err := svc.Exec("hello", map[string]string{"x": "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r2, err := svc.Exec("hello", map[string]string{"x": "2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		agents := make(map[string]bool)
+		for i, rID := range []model.RunID{r1, r2} {
+			r := completed("hello", rID)
+			if r.Outcome != model.Succeeded {
+				t.Errorf("Unexpected run: %+v", r)
+			}
+			agents[r.Agent] = true
+
+			log, err := svc.StdStreams("hello", rID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []string{"hi 1", "hi 2"}[i]
+			if lines := log.Lines(); len(lines) != 1 || lines[0].Text != expected {
+				t.Errorf("Expected output %q, got: %+v", expected, lines)
+			}
+		}
+		if !agents["db1"] || !agents["db2"] {
+			t.Errorf("Expected runs on both agents, got: %v", agents)
+		}
+	})
+
+	t.Run("StartError", func(t *testing.T) {
+		rID, err := svc.Exec("missing", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := completed("missing", rID); r.Outcome != model.Error || r.Error == "" {
+			t.Errorf("Unexpected run: %+v", r)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		rID, err := svc.Exec("long", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "output", func() bool {
+			log, err := svc.StdStreams("long", rID)
+			return err == nil && len(log.Lines()) > 0
+		})
+		if err := svc.Cancel("long", rID); err != nil {
+			t.Fatal(err)
+		}
+		if r := completed("long", rID); r.Outcome != model.Canceled || r.ExitCode != 5 {
+			t.Errorf("Unexpected run: %+v", r)
+		}
+	})
+
+	t.Run("Reconnect", func(t *testing.T) {
+		svc.ConnectAgent("db1", nil).Close() // replaces the connection of the agent
+		if connected("db1") {
+			t.Fatal("Expected db1 to be disconnected")
+		}
+		waitFor(t, "db1 to reconnect", func() bool { return connected("db1") })
+	})
+}
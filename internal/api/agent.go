@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+// AgentPingInterval is the time between pings to connected agents. Agents reconnect when they
+// receive no message for several intervals.
+const AgentPingInterval = 15 * time.Second
+
+// Agent describes the state of a model.Agent in API responses.
+type Agent struct {
+	Name      string
+	Labels    map[string]string `json:",omitempty"` // advertised when the agent last connected
+	Connected bool
+	Since     time.Time // when the agent connected or, if not Connected, disconnected
+	LastSeen  time.Time // last message to or from the agent, zero if it never connected
+	Runs      int       // runs currently assigned to the agent
+}
+
+// AgentMessage is sent by the server on the stream of a connected agent, see ConnectAgent.
+// Exactly one field is set.
+type AgentMessage struct {
+	Ping   bool      `json:",omitempty"` // sent regularly to keep the connection alive
+	Run    *AgentRun `json:",omitempty"` // a run to start
+	Cancel *AgentRun `json:",omitempty"` // a run to cancel, only TaskID and RunID are set
+}
+
+// AgentRun is a run assigned to an agent.
+type AgentRun struct {
+	TaskID model.TaskID
+	RunID  model.RunID
+	Cmd    string   `json:",omitempty"`
+	Args   []string `json:",omitempty"`
+	Dir    string   `json:",omitempty"`
+	Env    []string `json:",omitempty"` // added to the environment of the agent
+	Stdin  string   `json:",omitempty"`
+}
+
+// AgentReport is sent by an agent on the stream reporting a run, see ReportRun. Exactly one
+// field is set. The report with Done is the last one.
+type AgentReport struct {
+	Line *stdstreams.Line `json:",omitempty"`
+	Done *AgentDone       `json:",omitempty"`
+}
+
+// AgentDone is the result of a run on an agent.
+type AgentDone struct {
+	ExitCode int
+	Error    string        `json:",omitempty"` // why the agent failed to run the process
+	CPUTime  time.Duration `json:",omitempty"`
+	MaxRSS   int64         `json:",omitempty"`
+}
+
+// AgentStream is the stream of messages to a connected agent.
+type AgentStream struct {
+	body io.ReadCloser
+	dec  *json.Decoder
+}
+
+// Next returns the next message, blocking until it arrives.
+func (s *AgentStream) Next() (*AgentMessage, error) {
+	var m AgentMessage
+	if err := s.dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Close ends the connection.
+func (s *AgentStream) Close() error {
+	return s.body.Close()
+}
+
+// ConnectAgent connects as the agent whose token the client uses, advertising labels. The
+// connection lasts until ctx is done, the stream is closed or the server goes away.
+func (c *Client) ConnectAgent(ctx context.Context, labels map[string]string) (*AgentStream, error) {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	form := url.Values{}
+	for _, k := range keys {
+		form.Add("label", k+"="+labels[k])
+	}
+
+	resp, err := c.stream(ctx, "GET", "/api/agent/connect?"+form.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return &AgentStream{resp.Body, json.NewDecoder(resp.Body)}, nil
+}
+
+// ReportRun streams the reports of a run assigned to the agent, encoded as JSON lines read
+// from r, see AgentReport. It returns when r is exhausted and the server received the reports.
+func (c *Client) ReportRun(ctx context.Context, tID model.TaskID, rID model.RunID, r io.Reader) error {
+	form := url.Values{"t": {string(tID)}, "r": {string(rID)}}
+	resp, err := c.stream(ctx, "POST", "/api/agent/report?"+form.Encode(), r)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Agents returns the state of the configured agents.
+func (c *Client) Agents() ([]Agent, error) {
+	var agents []Agent
+	_, err := c.do("GET", "/api/agents", nil, &agents)
+	return agents, err
+}
+
+// stream sends a request with a streaming body and returns the response with its body left
+// open for streaming.
+func (c *Client) stream(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("%v %v: %v: %v", method, strings.SplitN(path, "?", 2)[0], resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,30 @@
+// Package api defines the JSON API of the optask server and provides a client for it.
+package api
+
+import (
+	"github.com/ngrash/optask/internal/model"
+)
+
+// Run describes a run in API responses.
+type Run struct {
+	model.Run
+	TaskID  model.TaskID
+	Running bool
+}
+
+// Task describes a task and its latest run in API responses.
+type Task struct {
+	ID          model.TaskID
+	Name        string
+	Description string   `json:",omitempty"`
+	Group       string   `json:",omitempty"`
+	Tags        []string `json:",omitempty"`
+	LastRun     *Run     // nil if the task never ran
+}
+
+// ExecResult is returned when a run was started or, for tasks requiring approval, requested.
+type ExecResult struct {
+	TaskID  model.TaskID
+	RunID   model.RunID    // empty if the run waits for approval
+	Request *model.Request `json:",omitempty"` // set if the run waits for approval
+}
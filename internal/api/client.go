@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+// Client talks to an optask server.
+type Client struct {
+	BaseURL string // e.g. http://localhost:8080
+	Token   string // token of a model.User, may be empty if the server has no users
+	HTTP    *http.Client
+}
+
+// NewClient creates a Client for the server at baseURL. A baseURL of the form unix:<path>
+// connects to a server listening on a Unix socket.
+func NewClient(baseURL, token string) *Client {
+	if !strings.HasPrefix(baseURL, "unix:") {
+		return &Client{strings.TrimSuffix(baseURL, "/"), token, http.DefaultClient}
+	}
+
+	path := strings.TrimPrefix(baseURL, "unix:")
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &Client{"http://unix", token, &http.Client{Transport: transport}}
+}
+
+// Exec starts a new run of a task. Runs of tasks requiring approval are requested instead,
+// see ExecResult. If override is set, the run starts even if the task is blocked by a freeze
+// or its maintenance windows.
+func (c *Client) Exec(tID model.TaskID, params map[string]string, override bool) (*ExecResult, error) {
+	form := url.Values{"t": {string(tID)}}
+	if override {
+		form.Set("override", "1")
+	}
+	for k, v := range params {
+		form.Add("p", k+"="+v)
+	}
+
+	var res ExecResult
+	_, err := c.do("POST", "/api/exec", form, &res)
+	return &res, err
+}
+
+// Cancel stops a running run.
+func (c *Client) Cancel(tID model.TaskID, rID model.RunID) error {
+	form := url.Values{"t": {string(tID)}, "r": {string(rID)}}
+	_, err := c.do("POST", "/api/cancel", form, nil)
+	return err
+}
+
+// Requests returns the runs waiting for approval.
+func (c *Client) Requests() ([]*model.Request, error) {
+	var reqs []*model.Request
+	_, err := c.do("GET", "/api/requests", nil, &reqs)
+	return reqs, err
+}
+
+// Approve starts the run of a request made by another user, see Exec for override.
+func (c *Client) Approve(id string, override bool) (*ExecResult, error) {
+	form := url.Values{"id": {id}}
+	if override {
+		form.Set("override", "1")
+	}
+	var res ExecResult
+	_, err := c.do("POST", "/api/approve", form, &res)
+	return &res, err
+}
+
+// Reject discards a request.
+func (c *Client) Reject(id string) error {
+	_, err := c.do("POST", "/api/reject", url.Values{"id": {id}}, nil)
+	return err
+}
+
+// Frozen returns the current freeze of the server, or nil if there is none.
+func (c *Client) Frozen() (*model.Freeze, error) {
+	var f *model.Freeze
+	_, err := c.do("GET", "/api/freeze", nil, &f)
+	return f, err
+}
+
+// Freeze blocks all runs for reason until Unfreeze is called.
+func (c *Client) Freeze(reason string) error {
+	_, err := c.do("POST", "/api/freeze", url.Values{"reason": {reason}}, nil)
+	return err
+}
+
+// Unfreeze lifts the current freeze.
+func (c *Client) Unfreeze() error {
+	_, err := c.do("POST", "/api/freeze", url.Values{"lift": {"1"}}, nil)
+	return err
+}
+
+// Run returns a single run.
+func (c *Client) Run(tID model.TaskID, rID model.RunID) (*Run, error) {
+	var r Run
+	_, err := c.do("GET", "/api/run", url.Values{"t": {string(tID)}, "r": {string(rID)}}, &r)
+	return &r, err
+}
+
+// History returns up to count runs of a task, latest first. If before is not empty, only runs
+// created before that run are returned.
+func (c *Client) History(tID model.TaskID, before model.RunID, count int) ([]*Run, error) {
+	form := url.Values{"t": {string(tID)}, "b": {string(before)}, "n": {strconv.Itoa(count)}}
+	var runs []*Run
+	_, err := c.do("GET", "/api/history", form, &runs)
+	return runs, err
+}
+
+// Status returns all tasks with their latest run or, if tag is not empty, only tasks with
+// that tag.
+func (c *Client) Status(tag string) ([]*Task, error) {
+	var form url.Values
+	if tag != "" {
+		form = url.Values{"tag": {tag}}
+	}
+	var tasks []*Task
+	_, err := c.do("GET", "/api/status", form, &tasks)
+	return tasks, err
+}
+
+// Lines returns the output of a run, skipping the first skip lines. The returned bool
+// indicates whether the run is still running and might produce more output.
+func (c *Client) Lines(tID model.TaskID, rID model.RunID, skip int) ([]stdstreams.Line, bool, error) {
+	form := url.Values{"t": {string(tID)}, "r": {string(rID)}, "s": {strconv.Itoa(skip)}}
+	var lines []stdstreams.Line
+	resp, err := c.do("GET", "/api/stdstreams", form, &lines)
+	if err != nil {
+		return nil, false, err
+	}
+	return lines, resp.Header.Get("Optask-Running") == "1", nil
+}
+
+// Backup writes a copy of the server's database to w, either the database file (format db)
+// or the portable export format (jsonl).
+func (c *Client) Backup(format string, w io.Writer) error {
+	resp, err := c.do("GET", "/api/backup", url.Values{"format": {format}}, w)
+	if err != nil {
+		return err
+	}
+	if resp.Trailer.Get("Optask-Error") != "" {
+		return fmt.Errorf("backup incomplete: %v", resp.Trailer.Get("Optask-Error"))
+	}
+	return nil
+}
+
+func (c *Client) do(method, path string, form url.Values, v interface{}) (*http.Response, error) {
+	u := c.BaseURL + path
+	var body *strings.Reader
+	if method == "GET" {
+		if len(form) > 0 {
+			u += "?" + form.Encode()
+		}
+		body = strings.NewReader("")
+	} else {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if method != "GET" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%v %v: %v: %v", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if w, ok := v.(io.Writer); ok {
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			return nil, err
+		}
+	} else if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
@@ -2,51 +2,419 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ngrash/optask/internal/credential"
 	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/window"
 )
 
+// validID matches IDs that are safe to use in URLs, file names and database bucket names.
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Error describes a single problem found in a configuration file.
+type Error struct {
+	File  string // path of the configuration file
+	Line  int    // 1-based line number, 0 if unknown
+	Col   int    // 1-based column number, 0 if unknown
+	Field string // path of the offending field, e.g. Tasks[2].Cmd
+	Msg   string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.File)
+	if e.Line > 0 {
+		fmt.Fprintf(&b, ":%d:%d", e.Line, e.Col)
+	}
+	b.WriteString(": ")
+	if e.Field != "" {
+		b.WriteString(e.Field)
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Msg)
+	return b.String()
+}
+
+// Errors is a list of problems found in a configuration file.
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
 // Read reads a project from the given file path and validates it.
+// Problems with the configuration are reported as Errors.
 func Read(path string) (*model.Project, error) {
-	file, err := os.Open(path)
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	defer file.Close()
+	return Parse(path, data)
+}
+
+// Parse decodes and validates a project from data. The name is used in error messages.
+func Parse(name string, data []byte) (*model.Project, error) {
+	pos := positions(data)
 
 	p := &model.Project{}
-	dec := json.NewDecoder(file)
-	dec.Decode(p)
+	if err := decode(data, p); err != nil {
+		if err.unknown != "" {
+			if path, ok := pos.find(err.unknown); ok {
+				err.field, err.offset = path, pos[path]
+			}
+		}
+		line, col := lineCol(data, err.offset)
+		return nil, Errors{{name, line, col, err.field, err.msg}}
+	}
 
-	return p, validate(p)
+	errs := validate(p)
+	for _, e := range errs {
+		e.File = name
+		e.Line, e.Col = lineCol(data, pos.lookup(e.Field))
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return p, nil
 }
 
-func validate(p *model.Project) error {
-	hasErr := false
-	for i, t := range p.Tasks {
-		hasErr = hasErr || logEmpty("ID", string(t.ID), i, t)
-		hasErr = hasErr || logEmpty("Name", t.Name, i, t)
-		hasErr = hasErr || logEmpty("Cmd", t.Cmd, i, t)
+type decodeError struct {
+	offset  int
+	field   string
+	msg     string
+	unknown string // name of an unknown field
+}
+
+func decode(data []byte, p *model.Project) *decodeError {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(p)
+	if err == io.EOF {
+		return &decodeError{msg: "empty configuration"}
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+	case errors.As(err, &syntaxErr):
+		return &decodeError{offset: int(syntaxErr.Offset), msg: syntaxErr.Error()}
+	case errors.As(err, &typeErr):
+		msg := fmt.Sprintf("cannot use JSON %v as %v", typeErr.Value, typeErr.Type)
+		return &decodeError{offset: int(typeErr.Offset), field: fieldPath(typeErr.Field), msg: msg}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		key, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return &decodeError{msg: "unknown field", unknown: key}
+	default:
+		return &decodeError{msg: strings.TrimPrefix(err.Error(), "json: ")}
 	}
 
-	if hasErr {
-		return fmt.Errorf("invalid config")
+	if dec.More() {
+		return &decodeError{offset: int(dec.InputOffset()), msg: "unexpected data after project"}
 	}
 
 	return nil
 }
 
-func logEmpty(attr string, v string, i int, t model.Task) bool {
-	if v == "" {
-		j, _ := json.Marshal(t)
-		log.Printf("Task (index: %v) missing attribute '%v': %v\n", i, attr, string(j))
-		return true
+// fieldPath converts a field path of encoding/json, e.g. Tasks.0.Args, to the form used by
+// validation errors, e.g. Tasks[0].Args.
+func fieldPath(jsonField string) string {
+	var b strings.Builder
+	for i, seg := range strings.Split(jsonField, ".") {
+		if _, err := strconv.Atoi(seg); err == nil && i > 0 {
+			fmt.Fprintf(&b, "[%v]", seg)
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(seg)
+	}
+	return b.String()
+}
+
+func validate(p *model.Project) Errors {
+	var errs Errors
+	fail := func(field, format string, a ...interface{}) {
+		errs = append(errs, &Error{Field: field, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	checkID := func(field, id string) {
+		if id == "" {
+			fail(field, "missing")
+		} else if !validID.MatchString(id) {
+			fail(field, "%q may only contain letters, digits, '_', '.' and '-' and must start with a letter or digit", id)
+		}
+	}
+
+	checkID("ID", p.ID)
+	if p.Name == "" {
+		fail("Name", "missing")
+	}
+
+	// checkTarget checks the Target of a task and rejects the features agents do not support.
+	checkTarget := func(field func(string) string, t model.Task) {
+		if len(p.Agents) == 0 {
+			fail(field("Target"), "requires Agents")
+		}
+
+		keys := make([]string, 0, len(t.Target))
+		for k := range t.Target {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			checkID(field("Target."+k), k)
+			if v := t.Target[k]; k == model.AgentLabel && !hasAgent(p, v) {
+				fail(field("Target."+k), "unknown agent %q", v)
+			}
+		}
+
+		unsupported := []struct {
+			name string
+			set  bool
+		}{
+			{"TTY", t.TTY},
+			{"Interactive", t.Interactive},
+			{"Artifacts", len(t.Artifacts) > 0},
+			{"Limits", t.Limits != (model.ResourceLimits{})},
+			{"RunAs", t.RunAs != (model.Credentials{})},
+		}
+		for _, u := range unsupported {
+			if u.set {
+				fail(field(u.name), "not supported with Target")
+			}
+		}
+	}
+
+	checkWindows := func(path string, ws []model.Window) {
+		for j, w := range ws {
+			wfield := func(name string) string {
+				return fmt.Sprintf("%s[%d].%s", path, j, name)
+			}
+			for k, d := range w.Days {
+				if _, err := window.ParseDay(d); err != nil {
+					fail(wfield(fmt.Sprintf("Days[%d]", k)), "%v", err)
+				}
+			}
+			if _, err := window.ParseClock(w.From); err != nil {
+				fail(wfield("From"), "%v", err)
+			}
+			if _, err := window.ParseClock(w.To); err != nil {
+				fail(wfield("To"), "%v", err)
+			}
+			if w.TimeZone != "" {
+				if _, err := time.LoadLocation(w.TimeZone); err != nil {
+					fail(wfield("TimeZone"), "%v", err)
+				}
+			}
+		}
 	}
 
+	seen := make(map[model.TaskID]int)
+	tags := make(map[string]bool)
+	for i, t := range p.Tasks {
+		field := func(name string) string {
+			return fmt.Sprintf("Tasks[%d].%s", i, name)
+		}
+		checkRegexps := func(name string, exprs []string) {
+			for j, expr := range exprs {
+				if _, err := regexp.Compile(expr); err != nil {
+					fail(field(fmt.Sprintf("%v[%d]", name, j)), "%v", err)
+				}
+			}
+		}
+
+		checkID(field("ID"), string(t.ID))
+		if j, ok := seen[t.ID]; ok && t.ID != "" {
+			fail(field("ID"), "duplicate task ID %q, already used by Tasks[%d]", t.ID, j)
+		} else {
+			seen[t.ID] = i
+		}
+
+		if t.Name == "" {
+			fail(field("Name"), "missing")
+		}
+
+		for j, tag := range t.Tags {
+			checkID(field(fmt.Sprintf("Tags[%d]", j)), tag)
+			tags[tag] = true
+		}
+
+		remote := len(t.Target) > 0 // Cmd and Dir are checked by the agent
+		if t.Cmd == "" {
+			fail(field("Cmd"), "missing")
+		} else if _, err := exec.LookPath(t.Cmd); err != nil && !remote {
+			fail(field("Cmd"), "%q not found in PATH", t.Cmd)
+		}
+
+		if t.TTYSize != (model.TTYSize{}) {
+			if !t.TTY {
+				fail(field("TTYSize"), "requires TTY")
+			} else if t.TTYSize.Rows == 0 || t.TTYSize.Cols == 0 {
+				fail(field("TTYSize"), "Rows and Cols must both be set")
+			}
+		}
+
+		checkRegexps("DiffIgnore", t.DiffIgnore)
+
+		if t.Dir != "" && !remote {
+			if fi, err := os.Stat(t.Dir); err != nil || !fi.IsDir() {
+				fail(field("Dir"), "%q is not a directory", t.Dir)
+			}
+		}
+
+		for j, pattern := range t.Artifacts {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				fail(field(fmt.Sprintf("Artifacts[%d]", j)), "%v", err)
+			} else if !filepath.IsLocal(pattern) {
+				fail(field(fmt.Sprintf("Artifacts[%d]", j)), "%q must be relative to Dir and not leave it", pattern)
+			}
+		}
+
+		if t.MaxArtifactsSize < 0 {
+			fail(field("MaxArtifactsSize"), "must not be negative")
+		}
+		if t.KeepRuns < 0 {
+			fail(field("KeepRuns"), "must not be negative")
+		}
+
+		if t.Limits.CPUQuota < 0 {
+			fail(field("Limits.CPUQuota"), "must not be negative")
+		}
+
+		if remote {
+			checkTarget(field, t)
+		}
+
+		if _, err := credential.Lookup(t.RunAs); err != nil && !remote {
+			fail(field("RunAs"), "%v", err)
+		}
+
+		if t.Approval && len(p.Users) < 2 {
+			fail(field("Approval"), "requires at least two Users")
+		}
+		if t.ApprovalMinutes < 0 {
+			fail(field("ApprovalMinutes"), "must not be negative")
+		}
+
+		checkWindows(field("Windows"), t.Windows)
+
+		checkRegexps("Success.FailurePatterns", t.Success.FailurePatterns)
+		checkRegexps("Success.WarningPatterns", t.Success.WarningPatterns)
+		ok := make(map[int]bool)
+		for _, c := range t.Success.ExitCodes {
+			ok[c] = true
+		}
+		if len(t.Success.ExitCodes) == 0 {
+			ok[0] = true
+		}
+		for j, c := range t.Success.WarningExitCodes {
+			if ok[c] {
+				fail(field(fmt.Sprintf("Success.WarningExitCodes[%d]", j)), "exit code %d is also successful", c)
+			}
+		}
+	}
+
+	tagNames := make([]string, 0, len(p.TagWindows))
+	for tag := range p.TagWindows {
+		tagNames = append(tagNames, tag)
+	}
+	sort.Strings(tagNames)
+	for _, tag := range tagNames {
+		if !tags[tag] {
+			fail("TagWindows."+tag, "no task has tag %q", tag)
+		}
+		checkWindows("TagWindows."+tag, p.TagWindows[tag])
+	}
+
+	agents := make(map[string]int)
+	tokens := make(map[string]string) // field of the user or agent by token
+	for i, a := range p.Agents {
+		field := func(name string) string {
+			return fmt.Sprintf("Agents[%d].%s", i, name)
+		}
+
+		checkID(field("Name"), a.Name)
+		if j, ok := agents[a.Name]; ok && a.Name != "" {
+			fail(field("Name"), "duplicate agent name %q, already used by Agents[%d]", a.Name, j)
+		} else {
+			agents[a.Name] = i
+		}
+
+		if a.Token == "" {
+			fail(field("Token"), "missing")
+		} else if f, ok := tokens[a.Token]; ok {
+			fail(field("Token"), "token already used by %v", f)
+		} else {
+			tokens[a.Token] = fmt.Sprintf("Agents[%d]", i)
+		}
+	}
+
+	names := make(map[string]int)
+	for i, u := range p.Users {
+		field := func(name string) string {
+			return fmt.Sprintf("Users[%d].%s", i, name)
+		}
+
+		if u.Name == "" {
+			fail(field("Name"), "missing")
+		} else if j, ok := names[u.Name]; ok {
+			fail(field("Name"), "duplicate user name %q, already used by Users[%d]", u.Name, j)
+		} else {
+			names[u.Name] = i
+		}
+
+		if u.Token == "" {
+			fail(field("Token"), "missing")
+		} else if f, ok := tokens[u.Token]; ok {
+			fail(field("Token"), "token already used by %v", f)
+		} else {
+			tokens[u.Token] = fmt.Sprintf("Users[%d]", i)
+		}
+	}
+
+	return errs
+}
+
+func hasAgent(p *model.Project, name string) bool {
+	for _, a := range p.Agents {
+		if a.Name == name {
+			return true
+		}
+	}
 	return false
 }
+
+func lineCol(data []byte, offset int) (line, col int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = offset - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data := `{
+	"ID": "testing",
+	"Name": "Testing",
+	"Tasks": [
+		{ "ID": "t1", "Name": "Task 1", "Cmd": "true" }
+	]
+}`
+
+	p, err := Parse("config.json", []byte(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(p.Tasks) != 1 {
+		t.Errorf("Expected 1 task, got: %v", len(p.Tasks))
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		line  int
+		field string
+		msg   string
+	}{
+		{
+			name: "Syntax",
+			data: "{\n\t\"ID\": \"testing\",\n}",
+			line: 3,
+			msg:  "invalid character",
+		},
+		{
+			name:  "UnknownField",
+			data:  "{\n\t\"ID\": \"testing\",\n\t\"Nmae\": \"Testing\"\n}",
+			line:  3,
+			field: "Nmae",
+			msg:   "unknown field",
+		},
+		{
+			name:  "WrongType",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\",\n\"Args\": \"-v\"}\n]}",
+			line:  3,
+			field: "Tasks[0].Args",
+			msg:   "cannot use JSON string",
+		},
+		{
+			name:  "DuplicateID",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\"},\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\"}\n]}",
+			line:  3,
+			field: "Tasks[1].ID",
+			msg:   "duplicate task ID",
+		},
+		{
+			name:  "UnsafeID",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"a/b\", \"Name\": \"T\", \"Cmd\": \"true\"}\n]}",
+			line:  2,
+			field: "Tasks[0].ID",
+			msg:   "may only contain",
+		},
+		{
+			name:  "MissingCmd",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\"}\n]}",
+			line:  2,
+			field: "Tasks[0].Cmd",
+			msg:   "missing",
+		},
+		{
+			name:  "CmdNotInPath",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\",\n\"Cmd\": \"optask-does-not-exist\"}\n]}",
+			line:  3,
+			field: "Tasks[0].Cmd",
+			msg:   "not found in PATH",
+		},
+		{
+			name:  "InvalidDiffIgnore",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\",\n\"DiffIgnore\": [\"[0-9\"]}\n]}",
+			line:  3,
+			field: "Tasks[0].DiffIgnore[0]",
+			msg:   "missing closing ]",
+		},
+		{
+			name:  "ArtifactsOutsideDir",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\",\n\"Artifacts\": [\"out/*.csv\", \"../*.csv\"]}\n]}",
+			line:  3,
+			field: "Tasks[0].Artifacts[1]",
+			msg:   "must be relative",
+		},
+		{
+			name:  "WarningExitCodeSucceeds",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\",\n\"Success\": {\"ExitCodes\": [0, 1], \"WarningExitCodes\": [1]}}\n]}",
+			line:  3,
+			field: "Tasks[0].Success.WarningExitCodes[0]",
+			msg:   "also successful",
+		},
+		{
+			name:  "ApprovalWithoutUsers",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\",\n\"Approval\": true}\n]}",
+			line:  3,
+			field: "Tasks[0].Approval",
+			msg:   "requires at least two Users",
+		},
+		{
+			name:  "InvalidTag",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\",\n\"Tags\": [\"db\", \"prod cluster\"]}\n]}",
+			line:  3,
+			field: "Tasks[0].Tags[1]",
+			msg:   "may only contain",
+		},
+		{
+			name:  "InvalidWindow",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\",\n\"Windows\": [{\"From\": \"22:00\", \"To\": \"6:00\"}]}\n]}",
+			line:  3,
+			field: "Tasks[0].Windows[0].To",
+			msg:   "expected HH:MM",
+		},
+		{
+			name:  "InvalidTagWindow",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\", \"Tags\": [\"db\"]}],\n\"TagWindows\": {\"db\": [\n{\"Days\": [\"Mon\"], \"From\": \"22:00\", \"To\": \"6:00\"}]}}",
+			line:  3,
+			field: "TagWindows.db[0].To",
+			msg:   "expected HH:MM",
+		},
+		{
+			name:  "UnknownTagWindow",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\", \"Tags\": [\"db\"]}],\n\"TagWindows\": {\"dv\": []}}",
+			line:  2,
+			field: "TagWindows.dv",
+			msg:   "no task has tag",
+		},
+		{
+			name:  "UnknownRunAsUser",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\",\n\"RunAs\": {\"User\": \"optask-no-such-user\"}}\n]}",
+			line:  3,
+			field: "Tasks[0].RunAs",
+			msg:   "unknown user",
+		},
+		{
+			name:  "TargetWithTTY",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Agents\": [{\"Name\": \"db1\", \"Token\": \"a\"}], \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"vacuumdb\", \"Target\": {\"role\": \"db\"},\n\"TTY\": true}\n]}",
+			line:  3,
+			field: "Tasks[0].TTY",
+			msg:   "not supported with Target",
+		},
+		{
+			name:  "UnknownTargetAgent",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Agents\": [{\"Name\": \"db1\", \"Token\": \"a\"}], \"Tasks\": [\n{\"ID\": \"t\", \"Name\": \"T\", \"Cmd\": \"true\",\n\"Target\": {\"agent\": \"db2\"}}\n]}",
+			line:  3,
+			field: "Tasks[0].Target.agent",
+			msg:   "unknown agent",
+		},
+		{
+			name:  "AgentTokenOfUser",
+			data:  "{\"ID\": \"p\", \"Name\": \"P\", \"Tasks\": [],\n\"Users\": [{\"Name\": \"alice\", \"Token\": \"a\"}],\n\"Agents\": [{\"Name\": \"db1\", \"Token\": \"a\"}]}",
+			line:  2,
+			field: "Users[0].Token",
+			msg:   "already used by Agents[0]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse("config.json", []byte(test.data))
+			errs, ok := err.(Errors)
+			if !ok || len(errs) != 1 {
+				t.Fatalf("Expected exactly one Error, got: %v", err)
+			}
+
+			e := errs[0]
+			if e.File != "config.json" {
+				t.Errorf("Expected File == \"config.json\", got: \"%v\"", e.File)
+			}
+			if e.Line != test.line {
+				t.Errorf("Expected Line == %v, got: %v (%v)", test.line, e.Line, e)
+			}
+			if e.Field != test.field {
+				t.Errorf("Expected Field == \"%v\", got: \"%v\"", test.field, e.Field)
+			}
+			if !strings.Contains(e.Msg, test.msg) {
+				t.Errorf("Expected Msg to contain \"%v\", got: \"%v\"", test.msg, e.Msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// positionMap maps field paths like Tasks[2].Cmd to byte offsets in a JSON document.
+type positionMap map[string]int
+
+// positions records the offset of every object key and array element in data. Invalid JSON
+// yields the positions found up to the first error.
+func positions(data []byte) positionMap {
+	pos := make(positionMap)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	walk(dec, data, "", pos)
+	return pos
+}
+
+func walk(dec *json.Decoder, data []byte, path string, pos positionMap) error {
+	if _, ok := pos[path]; !ok {
+		pos[path] = skipSeparators(data, int(dec.InputOffset()))
+	}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			start := skipSeparators(data, int(dec.InputOffset()))
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+
+			child := fmt.Sprint(key)
+			if path != "" {
+				child = path + "." + child
+			}
+
+			pos[child] = start
+			if err := walk(dec, data, child, pos); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := walk(dec, data, fmt.Sprintf("%s[%d]", path, i), pos); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	}
+
+	return err
+}
+
+// lookup returns the offset of field or of its closest known parent. Field names are matched
+// case-insensitively, like encoding/json does.
+func (pos positionMap) lookup(field string) int {
+	for {
+		if off, ok := pos[field]; ok {
+			return off
+		}
+		for path, off := range pos {
+			if strings.EqualFold(path, field) {
+				return off
+			}
+		}
+
+		i := strings.LastIndexAny(field, ".[")
+		if i < 0 {
+			return pos[""]
+		}
+		field = field[:i]
+	}
+}
+
+func skipSeparators(data []byte, off int) int {
+	for off < len(data) && strings.IndexByte(" \t\r\n,:", data[off]) >= 0 {
+		off++
+	}
+	return off
+}
+
+// find returns the first path whose last element is the given key.
+func (pos positionMap) find(key string) (string, bool) {
+	found, off := "", -1
+	for path, o := range pos {
+		if (path == key || strings.HasSuffix(path, "."+key)) && (off < 0 || o < off) {
+			found, off = path, o
+		}
+	}
+	return found, off >= 0
+}
@@ -0,0 +1,99 @@
+// Package credential resolves the Unix user and group a task runs as, see model.Task.RunAs.
+package credential
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+// Credential is a resolved model.Credentials.
+type Credential struct {
+	Name   string // user name
+	Home   string // home directory of the user
+	UID    uint32
+	GID    uint32
+	Groups []uint32 // supplementary groups of the user
+}
+
+// Lookup resolves c. It returns nil if c is empty.
+func Lookup(c model.Credentials) (*Credential, error) {
+	if c == (model.Credentials{}) {
+		return nil, nil
+	}
+	if c.User == "" {
+		return nil, fmt.Errorf("group %q requires a user", c.Group)
+	}
+
+	u, err := lookupUser(c.User)
+	if err != nil {
+		return nil, err
+	}
+	cred := &Credential{Name: u.Username, Home: u.HomeDir}
+	if cred.UID, err = parseID(u.Uid); err != nil {
+		return nil, err
+	}
+	if cred.GID, err = parseID(u.Gid); err != nil {
+		return nil, err
+	}
+
+	if c.Group != "" {
+		g, err := lookupGroup(c.Group)
+		if err != nil {
+			return nil, err
+		}
+		if cred.GID, err = parseID(g.Gid); err != nil {
+			return nil, err
+		}
+	}
+
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("looking up groups of user %q: %w", c.User, err)
+	}
+	for _, id := range ids {
+		gid, err := parseID(id)
+		if err != nil {
+			return nil, err
+		}
+		cred.Groups = append(cred.Groups, gid)
+	}
+	return cred, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if _, ok := err.(user.UnknownUserError); ok && isID(name) {
+		u, err = user.LookupId(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %q", name)
+	}
+	return u, nil
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	g, err := user.LookupGroup(name)
+	if _, ok := err.(user.UnknownGroupError); ok && isID(name) {
+		g, err = user.LookupGroupId(name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unknown group %q", name)
+	}
+	return g, nil
+}
+
+func isID(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 32)
+	return err == nil
+}
+
+func parseID(s string) (uint32, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user or group ID %q", s)
+	}
+	return uint32(id), nil
+}
@@ -0,0 +1,32 @@
+package credential
+
+import (
+	"testing"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+func TestLookup(t *testing.T) {
+	if c, err := Lookup(model.Credentials{}); c != nil || err != nil {
+		t.Errorf("Lookup of empty credentials = %v, %v, want nil, nil", c, err)
+	}
+
+	c, err := Lookup(model.Credentials{User: "0", Group: "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.UID != 0 || c.GID != 0 {
+		t.Errorf("Lookup(0, 0) = %d:%d, want 0:0", c.UID, c.GID)
+	}
+
+	bad := []model.Credentials{
+		{User: "optask-no-such-user"},
+		{User: "0", Group: "optask-no-such-group"},
+		{Group: "0"},
+	}
+	for _, b := range bad {
+		if _, err := Lookup(b); err == nil {
+			t.Errorf("Lookup(%+v) succeeded, want error", b)
+		}
+	}
+}
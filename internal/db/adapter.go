@@ -4,12 +4,13 @@ package db
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/gob"
+	"log"
 	"strconv"
 	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/search"
 	"github.com/ngrash/optask/internal/stdstreams"
 )
 
@@ -23,7 +24,8 @@ type Adapter struct {
 }
 
 // NewAdapter creates an Adapter for the given database file. If the file does not exist, a
-// dabase is created. Otherwise the database is opened and the schema is updated if necessary.
+// dabase is created. Otherwise the database is opened and migrated to the current schema if
+// necessary, keeping a backup of the previous version. See Migrate.
 func NewAdapter(file string, p *model.Project) (*Adapter, error) {
 	opts := bolt.Options{
 		Timeout: openTimeout, // w/o Timeout bolt.Open blocks until file is unlocked
@@ -34,8 +36,14 @@ func NewAdapter(file string, p *model.Project) (*Adapter, error) {
 		return nil, err
 	}
 
-	err = updateSchema(db, p)
+	_, err = migrateBolt(db, file, MigrateOptions{Backup: true, Logf: log.Printf})
 	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := createTaskBuckets(db, p); err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -177,11 +185,11 @@ func (a *Adapter) Run(tID model.TaskID, rID model.RunID) (*model.Run, error) {
 	return ret, err
 }
 
-// SaveLog persists a stdstreams.Log for a given task and run.
+// SaveLog persists a stdstreams.Log for a given task and run and adds its lines to the
+// search index.
 func (a *Adapter) SaveLog(tID model.TaskID, rID model.RunID, l *stdstreams.Log) error {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	if err := enc.Encode(l); err != nil {
+	data, err := encLog(l)
+	if err != nil {
 		return err
 	}
 
@@ -192,9 +200,65 @@ func (a *Adapter) SaveLog(tID model.TaskID, rID model.RunID, l *stdstreams.Log)
 
 	return a.db.Update(func(tx *bolt.Tx) error {
 		bkt := taskLogBucket(tx, tID)
-		bkt.Put(key, buf.Bytes())
+		bkt.Put(key, data)
+		return indexLog(tx, tID, key, l)
+	})
+}
+
+// DeleteRun deletes a run together with its log and search index entries.
+func (a *Adapter) DeleteRun(tID model.TaskID, rID model.RunID) error {
+	key, err := stob(string(rID))
+	if err != nil {
+		return err
+	}
+
+	return a.db.Update(func(tx *bolt.Tx) error {
+		logs := taskLogBucket(tx, tID)
+		if data := logs.Get(key); data != nil {
+			l, err := decLog(data)
+			if err != nil {
+				return err
+			}
+			index := tx.Bucket([]byte("Index"))
+			for _, k := range indexKeys(tID, key, l) {
+				if err := index.Delete(k); err != nil {
+					return err
+				}
+			}
+			if err := logs.Delete(key); err != nil {
+				return err
+			}
+		}
+		return taskRunBucket(tx, tID).Delete(key)
+	})
+}
+
+// Lookup returns the runs whose logs contain words starting with each of the given search
+// tokens. See search.Tokens.
+func (a *Adapter) Lookup(tokens []string) ([]search.Ref, error) {
+	var ret []search.Ref
+	err := a.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("Index")).Cursor()
+
+		var found map[search.Ref]bool
+		for _, t := range tokens {
+			refs := make(map[search.Ref]bool)
+			prefix := []byte(t)
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				ref := decIndexKey(k[bytes.IndexByte(k, 0)+1:])
+				if found == nil || found[ref] {
+					refs[ref] = true
+				}
+			}
+			found = refs
+		}
+
+		for ref := range found {
+			ret = append(ret, ref)
+		}
 		return nil
 	})
+	return ret, err
 }
 
 // Log returns a pointer to a stdstreams.Log associated with the given task and run.
@@ -204,44 +268,63 @@ func (a *Adapter) Log(tID model.TaskID, rID model.RunID) (*stdstreams.Log, error
 		return nil, err
 	}
 
-	var ret stdstreams.Log
+	var ret *stdstreams.Log
 	err = a.db.View(func(tx *bolt.Tx) error {
 		bkt := taskLogBucket(tx, tID)
-		data := bkt.Get(key)
-		buf := bytes.NewBuffer(data)
-		dec := gob.NewDecoder(buf)
-		return dec.Decode(&ret)
+		ret, err = decLog(bkt.Get(key))
+		return err
 	})
-	return &ret, err
-}
-
-func taskRunBucket(tx *bolt.Tx, tID model.TaskID) *bolt.Bucket {
-	return tx.Bucket([]byte("Runs")).Bucket([]byte(tID))
+	return ret, err
 }
 
-func taskLogBucket(tx *bolt.Tx, tID model.TaskID) *bolt.Bucket {
-	return tx.Bucket([]byte("Logs")).Bucket([]byte(tID))
+// indexLog adds an index entry token\x00taskID\x00runKey for each token in l.
+func indexLog(tx *bolt.Tx, tID model.TaskID, rKey []byte, l *stdstreams.Log) error {
+	bkt := tx.Bucket([]byte("Index"))
+	for _, key := range indexKeys(tID, rKey, l) {
+		if err := bkt.Put(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func encRun(r *model.Run) ([]byte, error) {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	if err := enc.Encode(r); err != nil {
-		return nil, err
+// indexKeys returns the keys token\x00taskID\x00runKey of the Index bucket for the tokens
+// in l.
+func indexKeys(tID model.TaskID, rKey []byte, l *stdstreams.Log) [][]byte {
+	var ret [][]byte
+	seen := make(map[string]bool)
+	for _, line := range l.Lines() {
+		for _, t := range search.Tokens(line.Text) {
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+
+			key := make([]byte, 0, len(t)+len(tID)+len(rKey)+2)
+			key = append(key, t...)
+			key = append(key, 0)
+			key = append(key, tID...)
+			key = append(key, 0)
+			key = append(key, rKey...)
+			ret = append(ret, key)
+		}
 	}
+	return ret
+}
 
-	return buf.Bytes(), nil
+// decIndexKey decodes the taskID\x00runKey part of an index key.
+func decIndexKey(k []byte) search.Ref {
+	i := bytes.IndexByte(k, 0)
+	rID := binary.BigEndian.Uint64(k[i+1:])
+	return search.Ref{TaskID: model.TaskID(k[:i]), RunID: model.RunID(itos(rID))}
 }
 
-func decRun(b []byte) (*model.Run, error) {
-	r := model.Run{}
-	buf := bytes.NewBuffer(b)
-	dec := gob.NewDecoder(buf)
-	if err := dec.Decode(&r); err != nil {
-		return nil, err
-	}
+func taskRunBucket(tx *bolt.Tx, tID model.TaskID) *bolt.Bucket {
+	return tx.Bucket([]byte("Runs")).Bucket([]byte(tID))
+}
 
-	return &r, nil
+func taskLogBucket(tx *bolt.Tx, tID model.TaskID) *bolt.Bucket {
+	return tx.Bucket([]byte("Logs")).Bucket([]byte(tID))
 }
 
 func itos(i uint64) string {
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -35,11 +36,34 @@ func TestCreateRun(t *testing.T) {
 
 func TestSaveRun(t *testing.T) {
 	withTmpDB(t, func(a *Adapter) {
-		r := model.Run{ID: "1"}
+		r := model.Run{
+			ID:         "1",
+			Summary:    "disk almost full",
+			Results:    []model.Result{{Name: "disk", Value: "83%"}},
+			Override:   "frozen: release",
+			OverrideBy: "alice",
+			Outcome:    model.Error,
+			Error:      "starting process: permission denied",
+			Agent:      "db1",
+		}
 		err := a.SaveRun(project.Tasks[0].ID, &r)
 		if err != nil {
 			t.Fatalf("Unexpected error. %v", err)
 		}
+
+		got, err := a.Run(project.Tasks[0].ID, "1")
+		if err != nil {
+			t.Fatalf("Unexpected error. %v", err)
+		}
+		if got.Summary != r.Summary || len(got.Results) != 1 || got.Results[0] != r.Results[0] {
+			t.Errorf("Expected summary and results to be saved, got: %+v", got)
+		}
+		if got.Override != r.Override || got.OverrideBy != r.OverrideBy {
+			t.Errorf("Expected override to be saved, got: %+v", got)
+		}
+		if got.Outcome != r.Outcome || got.Error != r.Error || got.Agent != r.Agent {
+			t.Errorf("Expected error to be saved, got: %+v", got)
+		}
 	})
 }
 
@@ -172,6 +196,116 @@ func TestLog(t *testing.T) {
 	})
 }
 
+func TestLookup(t *testing.T) {
+	withTmpDB(t, func(a *Adapter) {
+		l1 := stdstreams.NewLog()
+		l1.Stdout().Write([]byte("connection refused\n"))
+		a.SaveLog(project.Tasks[0].ID, "1", l1)
+
+		l2 := stdstreams.NewLog()
+		l2.Stderr().Write([]byte("connection reset\n"))
+		a.SaveLog(project.Tasks[1].ID, "2", l2)
+
+		refs, err := a.Lookup([]string{"connection", "refused"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if len(refs) != 1 {
+			t.Fatalf("Expected 1 ref, got: %v", len(refs))
+		}
+
+		if refs[0].TaskID != project.Tasks[0].ID || refs[0].RunID != "1" {
+			t.Errorf("Expected ref to t1/1, got: %v", refs[0])
+		}
+
+		if refs, _ := a.Lookup([]string{"conn"}); len(refs) != 2 {
+			t.Errorf("Expected 2 refs for a prefix, got: %v", refs)
+		}
+		if refs, _ := a.Lookup([]string{"conn", "ref"}); len(refs) != 1 {
+			t.Errorf("Expected 1 ref for prefixes, got: %v", refs)
+		}
+		if refs, _ := a.Lookup([]string{"onnection"}); len(refs) != 0 {
+			t.Errorf("Expected no refs, got: %v", refs)
+		}
+	})
+}
+
+func TestDeleteRun(t *testing.T) {
+	withTmpDB(t, func(a *Adapter) {
+		tID := project.Tasks[0].ID
+		r := model.Run{Artifacts: []model.Artifact{{Name: "report.csv", Size: 3, SHA256: "abc"}}}
+		a.CreateRun(tID, &r)
+		l := stdstreams.NewLog()
+		l.Stdout().Write([]byte("connection refused\n"))
+		a.SaveLog(tID, r.ID, l)
+
+		if got, _ := a.Run(tID, r.ID); len(got.Artifacts) != 1 || got.Artifacts[0] != r.Artifacts[0] {
+			t.Errorf("Expected artifacts %v, got: %v", r.Artifacts, got.Artifacts)
+		}
+
+		if err := a.DeleteRun(tID, r.ID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		if runs, _ := a.Runs(tID, "", 10); len(runs) != 0 {
+			t.Errorf("Expected no runs, got: %v", runs)
+		}
+		if refs, _ := a.Lookup([]string{"connection"}); len(refs) != 0 {
+			t.Errorf("Expected no refs, got: %v", refs)
+		}
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	var buf bytes.Buffer
+	withTmpDB(t, func(a *Adapter) {
+		tID := project.Tasks[0].ID
+		r := model.Run{ExitCode: 3}
+		a.CreateRun(tID, &r)
+
+		l := stdstreams.NewLog()
+		l.Stdout().Write([]byte("exported\n"))
+		a.SaveLog(tID, r.ID, l)
+
+		if err := a.Export(&buf); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	})
+
+	withTmpDB(t, func(a *Adapter) {
+		data := buf.Bytes()
+		n, err := a.Import(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("Expected 1 run imported, got: %v", n)
+		}
+
+		tID := project.Tasks[0].ID
+		r, err := a.Run(tID, "1")
+		if err != nil || r.ExitCode != 3 {
+			t.Errorf("Expected imported run with exit code 3, got: %v, %v", r, err)
+		}
+
+		refs, _ := a.Lookup([]string{"exported"})
+		if len(refs) != 1 {
+			t.Errorf("Expected imported log to be indexed, got: %v", refs)
+		}
+
+		next := model.Run{}
+		a.CreateRun(tID, &next)
+		if next.ID != "2" {
+			t.Errorf("Expected next rID == 2, got: %v", next.ID)
+		}
+
+		if _, err := a.Import(bytes.NewReader(data)); err == nil {
+			t.Errorf("Expected error importing existing run")
+		}
+	})
+}
+
 func withTmpDB(t *testing.T, fn func(*Adapter)) {
 	f, err := ioutil.TempFile("", "optask-testing.*.db")
 	if err != nil {
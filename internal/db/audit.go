@@ -0,0 +1,109 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/ngrash/optask/internal/model"
+)
+
+// AuditFilter selects entries of the audit log. Zero fields match all entries.
+type AuditFilter struct {
+	Before uint64 // only entries with a lower ID, for paging
+	User   string
+	Action string
+	TaskID model.TaskID
+	Since  time.Time
+	Until  time.Time
+}
+
+// Match reports whether e is selected by f.
+func (f AuditFilter) Match(e *model.AuditEntry) bool {
+	return (f.Before == 0 || e.ID < f.Before) &&
+		(f.User == "" || e.User == f.User) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.TaskID == "" || e.TaskID == f.TaskID) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// auditRecord is the stored representation of a model.AuditEntry, see runRecord.
+type auditRecord struct {
+	ID         uint64    `json:"ID"`
+	Time       time.Time `json:"Time"`
+	User       string    `json:"User,omitempty"`
+	RemoteAddr string    `json:"RemoteAddr,omitempty"`
+	Action     string    `json:"Action"`
+	TaskID     string    `json:"TaskID,omitempty"`
+	RunID      string    `json:"RunID,omitempty"`
+	Detail     string    `json:"Detail,omitempty"`
+}
+
+func encAudit(e *model.AuditEntry) ([]byte, error) {
+	return json.Marshal(auditRecord{e.ID, e.Time, e.User, e.RemoteAddr, e.Action, string(e.TaskID), string(e.RunID), e.Detail})
+}
+
+func decAudit(b []byte) (*model.AuditEntry, error) {
+	var rec auditRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+	return &model.AuditEntry{
+		ID:         rec.ID,
+		Time:       rec.Time,
+		User:       rec.User,
+		RemoteAddr: rec.RemoteAddr,
+		Action:     rec.Action,
+		TaskID:     model.TaskID(rec.TaskID),
+		RunID:      model.RunID(rec.RunID),
+		Detail:     rec.Detail,
+	}, nil
+}
+
+// AppendAudit appends an entry to the audit log and sets its ID. Entries are never changed or
+// deleted.
+func (a *Adapter) AppendAudit(e *model.AuditEntry) error {
+	return a.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte("Audit"))
+		id, err := bkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = id
+
+		data, err := encAudit(e)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(itob(id), data)
+	})
+}
+
+// Audit returns up to count entries of the audit log matching f, latest first.
+func (a *Adapter) Audit(f AuditFilter, count int) ([]*model.AuditEntry, error) {
+	var ret []*model.AuditEntry
+	err := a.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("Audit")).Cursor()
+
+		var k, v []byte
+		if f.Before == 0 {
+			k, v = c.Last()
+		} else {
+			c.Seek(itob(f.Before))
+			k, v = c.Prev()
+		}
+
+		for ; k != nil && len(ret) < count; k, v = c.Prev() {
+			e, err := decAudit(v)
+			if err != nil {
+				return err
+			}
+			if f.Match(e) {
+				ret = append(ret, e)
+			}
+		}
+		return nil
+	})
+	return ret, err
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+func TestAudit(t *testing.T) {
+	withTmpDB(t, func(a *Adapter) { testAudit(t, a) })
+	withTmpSQLite(t, func(s *SQLiteStore) { testAudit(t, s) })
+}
+
+func testAudit(t *testing.T, s Store) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+	entries := []model.AuditEntry{
+		{Time: start, User: "alice", Action: model.AuditLogin},
+		{Time: start.Add(time.Minute), User: "alice", Action: model.AuditExec, TaskID: "t1", RunID: "1"},
+		{Time: start.Add(2 * time.Minute), Action: model.AuditDenied, Detail: "/api/exec"},
+		{Time: start.Add(3 * time.Minute), User: "bob", Action: model.AuditCancel, TaskID: "t1", RunID: "1"},
+	}
+	for i := range entries {
+		if err := s.AppendAudit(&entries[i]); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if entries[i].ID != uint64(i+1) {
+			t.Errorf("Expected ID %d, got: %d", i+1, entries[i].ID)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		filter   AuditFilter
+		count    int
+		expected []uint64
+	}{
+		{"All", AuditFilter{}, 10, []uint64{4, 3, 2, 1}},
+		{"Count", AuditFilter{}, 2, []uint64{4, 3}},
+		{"Before", AuditFilter{Before: 3}, 10, []uint64{2, 1}},
+		{"User", AuditFilter{User: "alice"}, 10, []uint64{2, 1}},
+		{"Action", AuditFilter{Action: model.AuditDenied}, 10, []uint64{3}},
+		{"Task", AuditFilter{TaskID: "t1"}, 10, []uint64{4, 2}},
+		{"Time", AuditFilter{Since: start.Add(time.Minute), Until: start.Add(3 * time.Minute)}, 10, []uint64{3, 2}},
+	}
+	for _, test := range tests {
+		got, err := s.Audit(test.filter, test.count)
+		if err != nil {
+			t.Fatalf("%v: Unexpected error: %v", test.name, err)
+		}
+		var ids []uint64
+		for _, e := range got {
+			ids = append(ids, e.ID)
+		}
+		if len(ids) != len(test.expected) {
+			t.Errorf("%v: Expected %v, got: %v", test.name, test.expected, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.expected[i] {
+				t.Errorf("%v: Expected %v, got: %v", test.name, test.expected, ids)
+				break
+			}
+		}
+	}
+
+	got, _ := s.Audit(AuditFilter{Action: model.AuditExec}, 1)
+	if len(got) != 1 || got[0].User != "alice" || got[0].RunID != "1" || !got[0].Time.Equal(entries[1].Time) {
+		t.Errorf("Expected exec entry, got: %+v", got)
+	}
+}
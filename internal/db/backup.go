@@ -0,0 +1,235 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/boltdb/bolt"
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+// Record is a run together with its log in the portable export format. An export is a
+// stream of JSON encoded records, one per line, see Store.Export and Store.Import.
+type Record struct {
+	TaskID model.TaskID
+	Run    *model.Run
+	Log    *stdstreams.Log `json:",omitempty"` // nil if the run has no log yet
+}
+
+// Backup writes a consistent copy of the database to w while the database stays usable.
+func (a *Adapter) Backup(w io.Writer) (int64, error) {
+	var n int64
+	err := a.db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// Export writes all runs and logs to w in the portable export format, oldest runs of each
+// task first. Like Backup, it reads from a single consistent transaction.
+func (a *Adapter) Export(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return a.db.View(func(tx *bolt.Tx) error {
+		runs := tx.Bucket([]byte("Runs"))
+		return runs.ForEach(func(tKey, _ []byte) error {
+			tID := model.TaskID(tKey)
+			logs := taskLogBucket(tx, tID)
+			return runs.Bucket(tKey).ForEach(func(rKey, rBin []byte) error {
+				r, err := decRun(rBin)
+				if err != nil {
+					return err
+				}
+
+				rec := Record{TaskID: tID, Run: r}
+				if logs != nil {
+					if data := logs.Get(rKey); data != nil {
+						if rec.Log, err = decLog(data); err != nil {
+							return err
+						}
+					}
+				}
+
+				return enc.Encode(&rec)
+			})
+		})
+	})
+}
+
+// Import adds the records read from r in the portable export format to the database.
+// Runs keep their IDs, so importing fails if a run with the same ID already exists.
+// All records are imported in a single transaction. It returns the number of runs imported.
+func (a *Adapter) Import(r io.Reader) (int, error) {
+	n := 0
+	err := a.db.Update(func(tx *bolt.Tx) error {
+		dec := json.NewDecoder(r)
+		for {
+			var rec Record
+			if err := dec.Decode(&rec); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("record %d: %v", n+1, err)
+			}
+			if rec.Run == nil {
+				return fmt.Errorf("record %d: missing run", n+1)
+			}
+			setDefaults(rec.Run)
+
+			if err := importRecord(tx, &rec); err != nil {
+				return fmt.Errorf("record %d: %v", n+1, err)
+			}
+			n++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func importRecord(tx *bolt.Tx, rec *Record) error {
+	key, err := stob(string(rec.Run.ID))
+	if err != nil {
+		return fmt.Errorf("invalid run ID %q", rec.Run.ID)
+	}
+
+	// tasks might have been removed from the configuration of this project
+	runs, err := tx.Bucket([]byte("Runs")).CreateBucketIfNotExists([]byte(rec.TaskID))
+	if err != nil {
+		return err
+	}
+	logs, err := tx.Bucket([]byte("Logs")).CreateBucketIfNotExists([]byte(rec.TaskID))
+	if err != nil {
+		return err
+	}
+
+	if runs.Get(key) != nil {
+		return fmt.Errorf("run %v of task %v exists", rec.Run.ID, rec.TaskID)
+	}
+
+	b, err := encRun(rec.Run)
+	if err != nil {
+		return err
+	}
+	if err := runs.Put(key, b); err != nil {
+		return err
+	}
+
+	// keep CreateRun from reusing imported IDs
+	if id := binary.BigEndian.Uint64(key); id > runs.Sequence() {
+		if err := runs.SetSequence(id); err != nil {
+			return err
+		}
+	}
+
+	if rec.Log == nil {
+		return nil
+	}
+
+	data, err := encLog(rec.Log)
+	if err != nil {
+		return err
+	}
+	if err := logs.Put(key, data); err != nil {
+		return err
+	}
+	return indexLog(tx, rec.TaskID, key, rec.Log)
+}
+
+// Restore replaces the database file with a copy read from r, e.g. one written by
+// Store.Backup. The copy is checked before the file is replaced. For Bolt databases, Restore
+// fails if the database is in use. SQLite databases do not lock, so the server must be stopped.
+func Restore(storage, file string, r io.Reader) error {
+	if _, err := os.Stat(file); err == nil && storage == Bolt {
+		// opening the database locks it, so this fails while a server uses it
+		db, err := bolt.Open(file, dbPerm, &bolt.Options{Timeout: openTimeout})
+		if err == bolt.ErrTimeout {
+			return fmt.Errorf("%v is in use, stop the server first", file)
+		} else if err != nil {
+			return fmt.Errorf("opening %v: %v", file, err)
+		}
+		db.Close()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), dbPerm); err != nil {
+		return err
+	}
+
+	check := checkBolt
+	if storage == SQLite {
+		check = checkSQLite
+	}
+	if err := check(tmp.Name()); err != nil {
+		return fmt.Errorf("invalid backup: %v", err)
+	}
+
+	if storage == SQLite {
+		// stale write-ahead log of the replaced database
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if err := os.Remove(file + suffix); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+// checkBolt verifies that file is an optask Bolt database.
+func checkBolt(file string) error {
+	db, err := bolt.Open(file, dbPerm, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		for _, name := range []string{"Runs", "Logs"} {
+			if tx.Bucket([]byte(name)) == nil {
+				return fmt.Errorf("bucket %v missing", name)
+			}
+		}
+		return nil
+	})
+}
+
+// checkSQLite verifies that file is an optask SQLite database.
+func checkSQLite(file string) error {
+	db, err := sql.Open("sqlite3", "file:"+file+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, table := range []string{"runs", "logs"} {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("table %v missing", table)
+		}
+	}
+	return nil
+}
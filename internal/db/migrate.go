@@ -0,0 +1,181 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+// A Migration changes the schema of a database to Version.
+type Migration struct {
+	Version     int
+	Description string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%d: %v", m.Version, m.Description)
+}
+
+// MigrateOptions control how databases are migrated.
+type MigrateOptions struct {
+	DryRun bool                                     // only report pending migrations
+	Backup bool                                     // copy an existing database to <file>.v<version>.bak first
+	Logf   func(format string, args ...interface{}) // reports migrations if not nil
+}
+
+func (o MigrateOptions) logf(format string, args ...interface{}) {
+	if o.Logf != nil {
+		o.Logf(format, args...)
+	}
+}
+
+type boltMigration struct {
+	Migration
+	fn func(tx *bolt.Tx) error
+}
+
+type sqliteMigration struct {
+	Migration
+	fn func(tx *sql.Tx) error
+}
+
+// Migrate brings the schema of the database file of the given storage backend up to date.
+// It returns the migrations that were applied, or those that would be applied if
+// opts.DryRun is set. All migrations are applied in a single transaction. It fails if file
+// does not exist.
+func Migrate(storage, file string, opts MigrateOptions) ([]Migration, error) {
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+
+	switch storage {
+	case Bolt:
+		db, err := bolt.Open(file, dbPerm, &bolt.Options{Timeout: openTimeout})
+		if err == bolt.ErrTimeout {
+			return nil, fmt.Errorf("%v is in use, stop the server first", file)
+		} else if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		return migrateBolt(db, file, opts)
+	case SQLite:
+		db, err := openSQLite(file)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		return migrateSQLite(db, file, opts)
+	}
+	return nil, fmt.Errorf("unknown storage %q", storage)
+}
+
+func migrateBolt(db *bolt.DB, file string, opts MigrateOptions) ([]Migration, error) {
+	var version int
+	db.View(func(tx *bolt.Tx) error {
+		version = boltVersion(tx)
+		return nil
+	})
+
+	var pending []boltMigration
+	var ret []Migration
+	for _, m := range boltMigrations {
+		if m.Version > version {
+			pending = append(pending, m)
+			ret = append(ret, m.Migration)
+		}
+	}
+	if len(pending) == 0 || opts.DryRun {
+		return ret, nil
+	}
+
+	if opts.Backup && version > 0 {
+		backup := fmt.Sprintf("%v.v%d.bak", file, version)
+		err := db.View(func(tx *bolt.Tx) error {
+			return tx.CopyFile(backup, dbPerm)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("backing up database before migration: %v", err)
+		}
+		opts.logf("backed up %v to %v", file, backup)
+	}
+
+	if version == 0 {
+		opts.Logf = nil // new database
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, m := range pending {
+			opts.logf("migrating %v to version %v", file, m)
+			if err := m.fn(tx); err != nil {
+				return fmt.Errorf("migration %v: %v", m, err)
+			}
+		}
+		return setBoltVersion(tx, pending[len(pending)-1].Version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func migrateSQLite(db *sql.DB, file string, opts MigrateOptions) ([]Migration, error) {
+	var version, tables int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return nil, err
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master`).Scan(&tables); err != nil {
+		return nil, err
+	}
+
+	var pending []sqliteMigration
+	var ret []Migration
+	for _, m := range sqliteMigrations {
+		if m.Version > version {
+			pending = append(pending, m)
+			ret = append(ret, m.Migration)
+		}
+	}
+	if len(pending) == 0 || opts.DryRun {
+		return ret, nil
+	}
+
+	// databases created before versions were recorded have tables at version 0
+	if opts.Backup && tables > 0 {
+		backup := fmt.Sprintf("%v.v%d.bak", file, version)
+		if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if _, err := db.Exec(`VACUUM INTO ?`, backup); err != nil {
+			return nil, fmt.Errorf("backing up database before migration: %v", err)
+		}
+		opts.logf("backed up %v to %v", file, backup)
+	}
+
+	if tables == 0 {
+		opts.Logf = nil // new database
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, m := range pending {
+		opts.logf("migrating %v to version %v", file, m)
+		if err := m.fn(tx); err != nil {
+			return nil, fmt.Errorf("migration %v: %v", m, err)
+		}
+	}
+
+	// PRAGMA does not support parameters
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, pending[len(pending)-1].Version)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+func TestMigrateMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "optask-migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, storage := range []string{Bolt, SQLite} {
+		file := filepath.Join(dir, "missing."+storage)
+		if _, err := Migrate(storage, file, MigrateOptions{DryRun: true}); !os.IsNotExist(err) {
+			t.Errorf("Expected a not exist error for %v, got: %v", storage, err)
+		}
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("Expected %v not to be created, got: %v", file, err)
+		}
+	}
+}
+
+// TestMigrateGob migrates a database of version 2, which encoded runs and logs with gob and
+// did not record its version.
+func TestMigrateGob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "optask-migrate-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "test.db")
+
+	db, err := bolt.Open(file, dbPerm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"Runs", "Logs", "Index"} {
+			bkt, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			if name == "Index" {
+				continue
+			}
+			tBkt, err := bkt.CreateBucket([]byte("t1"))
+			if err != nil {
+				return err
+			}
+
+			var buf bytes.Buffer
+			if name == "Runs" {
+				gob.NewEncoder(&buf).Encode(&gobRun{ID: "1", Started: time.Now(), Completed: time.Now(), ExitCode: 7})
+			} else {
+				l := stdstreams.NewLog()
+				l.Stdout().Write([]byte("old output\n"))
+				gob.NewEncoder(&buf).Encode(l)
+			}
+			tBkt.Put(itob(1), buf.Bytes())
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := Migrate(Bolt, file, MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	latest := boltMigrations[len(boltMigrations)-1].Version
+	if len(pending) != latest-2 || pending[0].Version != 3 {
+		t.Errorf("Expected migrations 3 to %d to be pending, got: %v", latest, pending)
+	}
+
+	a, err := NewAdapter(file, project)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer a.Close()
+
+	r, err := a.Run("t1", "1")
+	if err != nil || r.ExitCode != 7 || r.Outcome != model.Failed {
+		t.Errorf("Expected migrated failed run with exit code 7, got: %v, %v", r, err)
+	}
+
+	l, err := a.Log("t1", "1")
+	if err != nil || len(l.Lines()) != 1 || l.Lines()[0].Text != "old output" {
+		t.Errorf("Expected migrated log, got: %v, %v", l, err)
+	}
+
+	if _, err := os.Stat(file + ".v2.bak"); err != nil {
+		t.Errorf("Expected backup of version 2: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+// runRecord is the stored representation of a model.Run. Fields are listed explicitly, so
+// changing model.Run does not change how existing records decode. New fields need a
+// migration if old records should get a value other than the zero value.
+type runRecord struct {
+	ID        string            `json:"ID"`
+	Started   time.Time         `json:"Started"`
+	Completed time.Time         `json:"Completed"`
+	ExitCode  int               `json:"ExitCode"`
+	Params    map[string]string `json:"Params,omitempty"`
+	Canceled  bool              `json:"Canceled,omitempty"`
+	Artifacts []artifactRecord  `json:"Artifacts,omitempty"`
+	Results   []resultRecord    `json:"Results,omitempty"`
+	Summary   string            `json:"Summary,omitempty"`
+	Outcome   string            `json:"Outcome,omitempty"`
+	Error     string            `json:"Error,omitempty"`
+	Agent     string            `json:"Agent,omitempty"`
+
+	MaxRSS  int64         `json:"MaxRSS,omitempty"`
+	CPUTime time.Duration `json:"CPUTime,omitempty"`
+
+	Override   string `json:"Override,omitempty"`
+	OverrideBy string `json:"OverrideBy,omitempty"`
+}
+
+type artifactRecord struct {
+	Name   string `json:"Name"`
+	Size   int64  `json:"Size"`
+	SHA256 string `json:"SHA256"`
+}
+
+type resultRecord struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+func encRun(r *model.Run) ([]byte, error) {
+	rec := runRecord{
+		ID:        string(r.ID),
+		Started:   r.Started,
+		Completed: r.Completed,
+		ExitCode:  r.ExitCode,
+		Params:    r.Params,
+		Canceled:  r.Canceled,
+		Summary:   r.Summary,
+		Outcome:   string(r.Outcome),
+		Error:     r.Error,
+		Agent:     r.Agent,
+
+		MaxRSS:  r.MaxRSS,
+		CPUTime: r.CPUTime,
+
+		Override:   r.Override,
+		OverrideBy: r.OverrideBy,
+	}
+	for _, a := range r.Artifacts {
+		rec.Artifacts = append(rec.Artifacts, artifactRecord{a.Name, a.Size, a.SHA256})
+	}
+	for _, res := range r.Results {
+		rec.Results = append(rec.Results, resultRecord{res.Name, res.Value})
+	}
+	return json.Marshal(rec)
+}
+
+func decRun(b []byte) (*model.Run, error) {
+	var rec runRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+
+	r := &model.Run{
+		ID:        model.RunID(rec.ID),
+		Started:   rec.Started,
+		Completed: rec.Completed,
+		ExitCode:  rec.ExitCode,
+		Params:    rec.Params,
+		Canceled:  rec.Canceled,
+		Summary:   rec.Summary,
+		Outcome:   model.Outcome(rec.Outcome),
+		Error:     rec.Error,
+		Agent:     rec.Agent,
+
+		MaxRSS:  rec.MaxRSS,
+		CPUTime: rec.CPUTime,
+
+		Override:   rec.Override,
+		OverrideBy: rec.OverrideBy,
+	}
+	for _, a := range rec.Artifacts {
+		r.Artifacts = append(r.Artifacts, model.Artifact{Name: a.Name, Size: a.Size, SHA256: a.SHA256})
+	}
+	for _, res := range rec.Results {
+		r.Results = append(r.Results, model.Result{Name: res.Name, Value: res.Value})
+	}
+	return r, nil
+}
+
+// setDefaults fills in fields of a run recorded before they existed.
+func setDefaults(r *model.Run) {
+	if r.Outcome == "" && !r.Completed.IsZero() {
+		r.Outcome = model.DefaultOutcome(r.ExitCode, r.Canceled)
+	}
+}
+
+// encLog encodes a log as JSON, see stdstreams.Log.MarshalJSON.
+func encLog(l *stdstreams.Log) ([]byte, error) {
+	return json.Marshal(l)
+}
+
+func decLog(b []byte) (*stdstreams.Log, error) {
+	var l stdstreams.Log
+	if err := json.Unmarshal(b, &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
@@ -1,37 +1,192 @@
 package db
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"time"
+
 	"github.com/boltdb/bolt"
 	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
 )
 
-func updateSchema(db *bolt.DB, p *model.Project) error {
-	return db.Update(func(tx *bolt.Tx) error {
-		// Create Runs bucket
-		rBkt, err := tx.CreateBucketIfNotExists([]byte("Runs"))
-		if err != nil {
+// boltMigrations are the schema versions of Bolt databases in order. Databases created
+// before versions were recorded are detected by boltVersion.
+var boltMigrations = []boltMigration{
+	{Migration{1, "create Runs and Logs buckets"}, func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte("Runs")); err != nil {
 			return err
 		}
-
-		// Create Logs bucket
-		lBkt, err := tx.CreateBucketIfNotExists([]byte("Logs"))
+		_, err := tx.CreateBucketIfNotExists([]byte("Logs"))
+		return err
+	}},
+	{Migration{2, "index logs for search"}, func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte("Index")); err != nil {
+			return err
+		}
+		return forEachLog(tx, func(tID, rKey, data []byte) error {
+			l, err := decGobLog(data)
+			if err != nil {
+				return err
+			}
+			return indexLog(tx, model.TaskID(tID), rKey, l)
+		})
+	}},
+	{Migration{3, "encode runs and logs as JSON instead of gob"}, func(tx *bolt.Tx) error {
+		err := forEachRun(tx, func(bkt *bolt.Bucket, rKey, data []byte) error {
+			var r gobRun
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&r); err != nil {
+				return err
+			}
+			b, err := encRun(&model.Run{ID: r.ID, Started: r.Started, Completed: r.Completed,
+				ExitCode: r.ExitCode, Params: r.Params, Canceled: r.Canceled})
+			if err != nil {
+				return err
+			}
+			return bkt.Put(rKey, b)
+		})
 		if err != nil {
-			return nil
+			return err
 		}
 
-		// Create bucket per task
-		for _, t := range p.Tasks {
-			_, err = rBkt.CreateBucketIfNotExists([]byte(t.ID))
+		return forEachLog(tx, func(tID, rKey, data []byte) error {
+			l, err := decGobLog(data)
 			if err != nil {
 				return err
 			}
-
-			_, err = lBkt.CreateBucketIfNotExists([]byte(t.ID))
+			b, err := encLog(l)
+			if err != nil {
+				return err
+			}
+			return tx.Bucket([]byte("Logs")).Bucket(tID).Put(rKey, b)
+		})
+	}},
+	{Migration{4, "record the outcome of completed runs"}, func(tx *bolt.Tx) error {
+		return forEachRun(tx, func(bkt *bolt.Bucket, rKey, data []byte) error {
+			r, err := decRun(data)
+			if err != nil {
+				return err
+			}
+			setDefaults(r)
+			b, err := encRun(r)
 			if err != nil {
 				return err
 			}
+			return bkt.Put(rKey, b)
+		})
+	}},
+	{Migration{5, "create Audit bucket"}, func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("Audit"))
+		return err
+	}},
+}
+
+// boltVersion returns the schema version of a Bolt database.
+func boltVersion(tx *bolt.Tx) int {
+	if meta := tx.Bucket([]byte("Meta")); meta != nil {
+		if v := meta.Get([]byte("version")); v != nil {
+			return int(binary.BigEndian.Uint64(v))
 		}
+	}
 
+	// databases of versions 1 and 2 did not record their version
+	switch {
+	case tx.Bucket([]byte("Index")) != nil:
+		return 2
+	case tx.Bucket([]byte("Runs")) != nil:
+		return 1
+	}
+	return 0
+}
+
+func setBoltVersion(tx *bolt.Tx, version int) error {
+	meta, err := tx.CreateBucketIfNotExists([]byte("Meta"))
+	if err != nil {
+		return err
+	}
+	return meta.Put([]byte("version"), itob(uint64(version)))
+}
+
+// createTaskBuckets creates the buckets for runs and logs of each task of p.
+func createTaskBuckets(db *bolt.DB, p *model.Project) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, t := range p.Tasks {
+			if _, err := tx.Bucket([]byte("Runs")).CreateBucketIfNotExists([]byte(t.ID)); err != nil {
+				return err
+			}
+			if _, err := tx.Bucket([]byte("Logs")).CreateBucketIfNotExists([]byte(t.ID)); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 }
+
+// entry is a copy of a key and value of a task bucket.
+type entry struct {
+	tID, key, data []byte
+}
+
+// entries copies all entries of the task buckets in the bucket name, so migrations can modify
+// them, which is not allowed while iterating with ForEach.
+func entries(tx *bolt.Tx, name string) ([]entry, error) {
+	var ret []entry
+	root := tx.Bucket([]byte(name))
+	err := root.ForEach(func(tID, _ []byte) error {
+		return root.Bucket(tID).ForEach(func(k, v []byte) error {
+			ret = append(ret, entry{copyBytes(tID), copyBytes(k), copyBytes(v)})
+			return nil
+		})
+	})
+	return ret, err
+}
+
+func forEachRun(tx *bolt.Tx, fn func(bkt *bolt.Bucket, rKey, data []byte) error) error {
+	runs, err := entries(tx, "Runs")
+	if err != nil {
+		return err
+	}
+	for _, e := range runs {
+		if err := fn(tx.Bucket([]byte("Runs")).Bucket(e.tID), e.key, e.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forEachLog(tx *bolt.Tx, fn func(tID, rKey, data []byte) error) error {
+	logs, err := entries(tx, "Logs")
+	if err != nil {
+		return err
+	}
+	for _, e := range logs {
+		if err := fn(e.tID, e.key, e.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+
+// gobRun is model.Run as it was encoded with gob up to version 2.
+type gobRun struct {
+	ID        model.RunID
+	Started   time.Time
+	Completed time.Time
+	ExitCode  int
+	Params    map[string]string
+	Canceled  bool
+}
+
+// decGobLog decodes a log encoded with gob up to version 2.
+func decGobLog(data []byte) (*stdstreams.Log, error) {
+	var l stdstreams.Log
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
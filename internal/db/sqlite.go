@@ -0,0 +1,559 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3" // registers the sqlite3 driver
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/search"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+const busyTimeout = 5000 // milliseconds to wait for locks held by other connections
+
+// sqliteMigrations are the schema versions of SQLite databases in order.
+var sqliteMigrations = []sqliteMigration{
+	{Migration{1, "create tables"}, func(tx *sql.Tx) error {
+		_, err := tx.Exec(sqliteSchema)
+		return err
+	}},
+	{Migration{2, "record the outcome of completed runs"}, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`ALTER TABLE runs ADD COLUMN outcome TEXT`); err != nil {
+			return err
+		}
+
+		type row struct {
+			tID  model.TaskID
+			data string
+		}
+		var rows []row
+		rs, err := tx.Query(`SELECT task_id, data FROM runs`)
+		if err != nil {
+			return err
+		}
+		for rs.Next() {
+			var r row
+			if err := rs.Scan(&r.tID, &r.data); err != nil {
+				rs.Close()
+				return err
+			}
+			rows = append(rows, r)
+		}
+		rs.Close()
+		if err := rs.Err(); err != nil {
+			return err
+		}
+
+		for _, r := range rows {
+			run, err := decRun([]byte(r.data))
+			if err != nil {
+				return err
+			}
+			setDefaults(run)
+			if err := putRun(tx, r.tID, run, "INSERT OR REPLACE"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{Migration{3, "create audit table"}, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE audit (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	time    TIMESTAMP NOT NULL,
+	user    TEXT NOT NULL,
+	action  TEXT NOT NULL,
+	task_id TEXT NOT NULL,
+	data    TEXT NOT NULL
+)`)
+		return err
+	}},
+}
+
+// sqliteSchema creates the tables of version 1. Runs and logs are stored as JSON in the data
+// columns; the other columns of runs duplicate fields of the run for SQL reports.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS sequences (
+	task_id TEXT PRIMARY KEY,
+	seq     INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS runs (
+	task_id   TEXT NOT NULL,
+	id        INTEGER NOT NULL,
+	started   TIMESTAMP,
+	completed TIMESTAMP,
+	exit_code INTEGER,
+	canceled  BOOLEAN,
+	data      TEXT NOT NULL,
+	PRIMARY KEY (task_id, id)
+);
+CREATE TABLE IF NOT EXISTS logs (
+	task_id TEXT NOT NULL,
+	run_id  INTEGER NOT NULL,
+	data    TEXT NOT NULL,
+	PRIMARY KEY (task_id, run_id)
+);
+CREATE TABLE IF NOT EXISTS tokens (
+	token   TEXT NOT NULL,
+	task_id TEXT NOT NULL,
+	run_id  INTEGER NOT NULL,
+	PRIMARY KEY (token, task_id, run_id)
+) WITHOUT ROWID;
+`
+
+// SQLiteStore is a Store using SQLite. Unlike BoltDB, SQLite allows other processes to read
+// the database while the server runs, e.g. for reports:
+//
+//	sqlite3 data/project.sqlite "SELECT task_id, COUNT(*) FROM runs WHERE outcome = 'failed' GROUP BY task_id"
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens or creates the SQLite database file. An existing database is migrated
+// to the current schema if necessary, keeping a backup of the previous version. See Migrate.
+func NewSQLiteStore(file string, p *model.Project) (*SQLiteStore, error) {
+	db, err := openSQLite(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := migrateSQLite(db, file, MigrateOptions{Backup: true, Logf: log.Printf}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db}, nil
+}
+
+func openSQLite(file string) (*sql.DB, error) {
+	// create the file with the permissions of Bolt databases, SQLite would use 0644
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR, dbPerm)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	dsn := fmt.Sprintf("file:%v?_journal_mode=WAL&_busy_timeout=%d", file, busyTimeout)
+	return sql.Open("sqlite3", dsn)
+}
+
+// Close closes the underlying database.
+func (s *SQLiteStore) Close() {
+	s.db.Close()
+}
+
+// CreateRun saves the given run for the given task. Sets a task-unique run ID before persisting.
+func (s *SQLiteStore) CreateRun(tID model.TaskID, r *model.Run) error {
+	return s.update(func(tx *sql.Tx) error {
+		var seq uint64
+		err := tx.QueryRow(`INSERT INTO sequences (task_id, seq) VALUES (?, 1)
+			ON CONFLICT (task_id) DO UPDATE SET seq = seq + 1 RETURNING seq`, string(tID)).Scan(&seq)
+		if err != nil {
+			return err
+		}
+
+		r.ID = model.RunID(itos(seq))
+		return putRun(tx, tID, r, "INSERT")
+	})
+}
+
+// SaveRun saves the given run for the given task. Use CreateRun instead if the run does not have an ID yet.
+func (s *SQLiteStore) SaveRun(tID model.TaskID, r *model.Run) error {
+	return s.update(func(tx *sql.Tx) error {
+		return putRun(tx, tID, r, "INSERT OR REPLACE")
+	})
+}
+
+func putRun(tx *sql.Tx, tID model.TaskID, r *model.Run, insert string) error {
+	id, err := strconv.ParseUint(string(r.ID), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	data, err := encRun(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(insert+` INTO runs (task_id, id, started, completed, exit_code, canceled, outcome, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, string(tID), id, r.Started, r.Completed, r.ExitCode, r.Canceled, string(r.Outcome), string(data))
+	return err
+}
+
+// LatestRuns returns a map of model.TaskID mapped to the latest run each.
+// If a task never ran, its ID will not be in the map.
+func (s *SQLiteStore) LatestRuns() (map[model.TaskID]*model.Run, error) {
+	rows, err := s.db.Query(`SELECT task_id, data FROM runs
+		WHERE (task_id, id) IN (SELECT task_id, MAX(id) FROM runs GROUP BY task_id)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make(map[model.TaskID]*model.Run)
+	for rows.Next() {
+		var tID, data string
+		if err := rows.Scan(&tID, &data); err != nil {
+			return nil, err
+		}
+
+		r, err := decRun([]byte(data))
+		if err != nil {
+			return nil, err
+		}
+		ret[model.TaskID(tID)] = r
+	}
+	return ret, rows.Err()
+}
+
+// Runs returns count runs for a given task ordered by time of creation. If before is not empty,
+// only runs that where created before that given run are returned.
+func (s *SQLiteStore) Runs(tID model.TaskID, before model.RunID, count int) ([]*model.Run, error) {
+	var b uint64 = 1<<63 - 1
+	if before != "" {
+		var err error
+		if b, err = strconv.ParseUint(string(before), 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err := s.db.Query(`SELECT data FROM runs WHERE task_id = ? AND id < ? ORDER BY id DESC LIMIT ?`,
+		string(tID), b, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]*model.Run, 0, count)
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+
+		r, err := decRun([]byte(data))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, rows.Err()
+}
+
+// Run returns a pointer to a mode.Run for the task with the given id.
+func (s *SQLiteStore) Run(tID model.TaskID, rID model.RunID) (*model.Run, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM runs WHERE task_id = ? AND id = ?`, string(tID), string(rID)).Scan(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	return decRun([]byte(data))
+}
+
+// SaveLog persists a stdstreams.Log for a given task and run and adds its lines to the
+// search index.
+func (s *SQLiteStore) SaveLog(tID model.TaskID, rID model.RunID, l *stdstreams.Log) error {
+	return s.update(func(tx *sql.Tx) error {
+		return putLog(tx, tID, rID, l)
+	})
+}
+
+func putLog(tx *sql.Tx, tID model.TaskID, rID model.RunID, l *stdstreams.Log) error {
+	data, err := encLog(l)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO logs (task_id, run_id, data) VALUES (?, ?, ?)`,
+		string(tID), string(rID), string(data))
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, line := range l.Lines() {
+		for _, t := range search.Tokens(line.Text) {
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+
+			_, err := tx.Exec(`INSERT OR IGNORE INTO tokens (token, task_id, run_id) VALUES (?, ?, ?)`,
+				t, string(tID), string(rID))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Log returns a pointer to a stdstreams.Log associated with the given task and run.
+func (s *SQLiteStore) Log(tID model.TaskID, rID model.RunID) (*stdstreams.Log, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM logs WHERE task_id = ? AND run_id = ?`, string(tID), string(rID)).Scan(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	return decLog([]byte(data))
+}
+
+// DeleteRun deletes a run together with its log and search index entries.
+func (s *SQLiteStore) DeleteRun(tID model.TaskID, rID model.RunID) error {
+	id, err := strconv.ParseUint(string(rID), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM tokens WHERE task_id = ? AND run_id = ?`, string(tID), string(rID)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM logs WHERE task_id = ? AND run_id = ?`, string(tID), string(rID)); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM runs WHERE task_id = ? AND id = ?`, string(tID), id)
+		return err
+	})
+}
+
+// Lookup returns the runs whose logs contain words starting with each of the given search
+// tokens. See search.Tokens.
+func (s *SQLiteStore) Lookup(tokens []string) ([]search.Ref, error) {
+	var found map[search.Ref]bool
+	for _, t := range tokens {
+		// tokens consist of letters and digits, so they contain no LIKE wildcards
+		rows, err := s.db.Query(`SELECT DISTINCT task_id, run_id FROM tokens WHERE token LIKE ?`, t+"%")
+		if err != nil {
+			return nil, err
+		}
+
+		refs := make(map[search.Ref]bool)
+		for rows.Next() {
+			var tID string
+			var rID uint64
+			if err := rows.Scan(&tID, &rID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ref := search.Ref{TaskID: model.TaskID(tID), RunID: model.RunID(itos(rID))}
+			if found == nil || found[ref] {
+				refs[ref] = true
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		found = refs
+	}
+
+	var ret []search.Ref
+	for ref := range found {
+		ret = append(ret, ref)
+	}
+	return ret, nil
+}
+
+// Backup writes a consistent copy of the database to w while the database stays usable.
+func (s *SQLiteStore) Backup(w io.Writer) (int64, error) {
+	dir, err := os.MkdirTemp("", "optask-backup-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "backup.sqlite")
+	if _, err := s.db.Exec(`VACUUM INTO ?`, file); err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return io.Copy(w, f)
+}
+
+// Export writes all runs and logs to w in the portable export format, oldest runs of each
+// task first. It reads from a single consistent transaction.
+func (s *SQLiteStore) Export(w io.Writer) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT runs.task_id, runs.data, logs.data FROM runs
+		LEFT JOIN logs ON logs.task_id = runs.task_id AND logs.run_id = runs.id
+		ORDER BY runs.task_id, runs.id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	enc := json.NewEncoder(w)
+	for rows.Next() {
+		var tID, run string
+		var log sql.NullString
+		if err := rows.Scan(&tID, &run, &log); err != nil {
+			return err
+		}
+
+		rec := Record{TaskID: model.TaskID(tID)}
+		if rec.Run, err = decRun([]byte(run)); err != nil {
+			return err
+		}
+		if log.Valid {
+			if rec.Log, err = decLog([]byte(log.String)); err != nil {
+				return err
+			}
+		}
+
+		if err := enc.Encode(&rec); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Import adds the records read from r in the portable export format to the database.
+// Runs keep their IDs, so importing fails if a run with the same ID already exists.
+// All records are imported in a single transaction. It returns the number of runs imported.
+func (s *SQLiteStore) Import(r io.Reader) (int, error) {
+	n := 0
+	err := s.update(func(tx *sql.Tx) error {
+		dec := json.NewDecoder(r)
+		for {
+			var rec Record
+			if err := dec.Decode(&rec); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("record %d: %v", n+1, err)
+			}
+			if rec.Run == nil {
+				return fmt.Errorf("record %d: missing run", n+1)
+			}
+			setDefaults(rec.Run)
+
+			if err := putRun(tx, rec.TaskID, rec.Run, "INSERT"); err != nil {
+				return fmt.Errorf("record %d: run %v of task %v: %v", n+1, rec.Run.ID, rec.TaskID, err)
+			}
+
+			// keep CreateRun from reusing imported IDs
+			id, _ := strconv.ParseUint(string(rec.Run.ID), 10, 64) // checked by putRun
+			_, err := tx.Exec(`INSERT INTO sequences (task_id, seq) VALUES (?, ?)
+				ON CONFLICT (task_id) DO UPDATE SET seq = MAX(seq, excluded.seq)`, string(rec.TaskID), id)
+			if err != nil {
+				return err
+			}
+
+			if rec.Log != nil {
+				if err := putLog(tx, rec.TaskID, rec.Run.ID, rec.Log); err != nil {
+					return fmt.Errorf("record %d: %v", n+1, err)
+				}
+			}
+			n++
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// update runs fn in a transaction, which is committed if fn returns no error.
+func (s *SQLiteStore) update(fn func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AppendAudit appends an entry to the audit log and sets its ID. Entries are never changed or
+// deleted.
+func (s *SQLiteStore) AppendAudit(e *model.AuditEntry) error {
+	return s.update(func(tx *sql.Tx) error {
+		var id uint64
+		err := tx.QueryRow(`INSERT INTO audit (time, user, action, task_id, data) VALUES (?, ?, ?, ?, '')
+			RETURNING id`, e.Time.UTC(), e.User, e.Action, string(e.TaskID)).Scan(&id)
+		if err != nil {
+			return err
+		}
+		e.ID = id
+
+		data, err := encAudit(e)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE audit SET data = ? WHERE id = ?`, string(data), id)
+		return err
+	})
+}
+
+// Audit returns up to count entries of the audit log matching f, latest first.
+func (s *SQLiteStore) Audit(f AuditFilter, count int) ([]*model.AuditEntry, error) {
+	where := []string{"1"}
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if f.Before != 0 {
+		add("id < ?", f.Before)
+	}
+	if f.User != "" {
+		add("user = ?", f.User)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.TaskID != "" {
+		add("task_id = ?", string(f.TaskID))
+	}
+	if !f.Since.IsZero() {
+		add("time >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		add("time < ?", f.Until.UTC())
+	}
+	args = append(args, count)
+
+	rows, err := s.db.Query(`SELECT data FROM audit WHERE `+strings.Join(where, " AND ")+
+		` ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []*model.AuditEntry
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		e, err := decAudit([]byte(data))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, e)
+	}
+	return ret, rows.Err()
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	withTmpSQLite(t, func(s *SQLiteStore) {
+		tID := project.Tasks[0].ID
+		for i := 0; i < 3; i++ {
+			r := model.Run{ExitCode: i}
+			if err := s.CreateRun(tID, &r); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+
+		runs, err := s.Runs(tID, "3", 10)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(runs) != 2 || runs[0].ID != "2" || runs[1].ID != "1" {
+			t.Errorf("Expected runs 2 and 1, got: %v", runs)
+		}
+
+		latest, err := s.LatestRuns()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if latest[tID].ID != "3" || latest[tID].ExitCode != 2 {
+			t.Errorf("Expected latest run 3, got: %v", latest[tID])
+		}
+
+		l := stdstreams.NewLog()
+		l.Stdout().Write([]byte("connection refused\n"))
+		if err := s.SaveLog(tID, "2", l); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		l, err = s.Log(tID, "2")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if l.Lines()[0].Text != "connection refused" {
+			t.Errorf("Expected Text == \"connection refused\", got: \"%v\"", l.Lines()[0].Text)
+		}
+
+		refs, err := s.Lookup([]string{"connection", "refused"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(refs) != 1 || refs[0].TaskID != tID || refs[0].RunID != "2" {
+			t.Errorf("Expected ref to t1/2, got: %v", refs)
+		}
+		if refs, _ := s.Lookup([]string{"conn", "ref"}); len(refs) != 1 {
+			t.Errorf("Expected a ref for prefixes, got: %v", refs)
+		}
+		if refs, _ := s.Lookup([]string{"onnection"}); len(refs) != 0 {
+			t.Errorf("Expected no refs, got: %v", refs)
+		}
+
+		if err := s.DeleteRun(tID, "2"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if runs, _ := s.Runs(tID, "", 10); len(runs) != 2 {
+			t.Errorf("Expected 2 runs, got: %v", runs)
+		}
+		if refs, _ := s.Lookup([]string{"connection"}); len(refs) != 0 {
+			t.Errorf("Expected no refs, got: %v", refs)
+		}
+	})
+}
+
+func TestSQLiteImportFromBolt(t *testing.T) {
+	var buf bytes.Buffer
+	withTmpDB(t, func(a *Adapter) {
+		r := model.Run{ExitCode: 1}
+		a.CreateRun(project.Tasks[1].ID, &r)
+		a.SaveLog(project.Tasks[1].ID, r.ID, stdstreams.NewLog())
+		a.Export(&buf)
+	})
+
+	withTmpSQLite(t, func(s *SQLiteStore) {
+		n, err := s.Import(&buf)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if n != 1 {
+			t.Errorf("Expected 1 run imported, got: %v", n)
+		}
+
+		r := model.Run{}
+		s.CreateRun(project.Tasks[1].ID, &r)
+		if r.ID != "2" {
+			t.Errorf("Expected rID == 2, got: %v", r.ID)
+		}
+	})
+}
+
+func withTmpSQLite(t *testing.T, fn func(*SQLiteStore)) {
+	dir, err := ioutil.TempDir("", "optask-sqlite-test")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewSQLiteStore(filepath.Join(dir, "test.sqlite"), project)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer s.Close()
+
+	fn(s)
+}
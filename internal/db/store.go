@@ -0,0 +1,72 @@
+package db
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/search"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+// Storage backends accepted by Open.
+const (
+	Bolt   = "bolt"
+	SQLite = "sqlite"
+)
+
+// Store persists runs and their logs. Adapter implements Store using BoltDB, SQLiteStore
+// using SQLite.
+type Store interface {
+	// CreateRun saves a new run, setting a task-unique run ID before persisting.
+	CreateRun(tID model.TaskID, r *model.Run) error
+	// SaveRun saves a run that has an ID already.
+	SaveRun(tID model.TaskID, r *model.Run) error
+	// LatestRuns returns the latest run of each task that ran at least once.
+	LatestRuns() (map[model.TaskID]*model.Run, error)
+	// Runs returns up to count runs of a task, latest first. If before is not empty, only
+	// runs created before that run are returned.
+	Runs(tID model.TaskID, before model.RunID, count int) ([]*model.Run, error)
+	Run(tID model.TaskID, rID model.RunID) (*model.Run, error)
+	// SaveLog saves the log of a run and adds its lines to the search index.
+	SaveLog(tID model.TaskID, rID model.RunID, l *stdstreams.Log) error
+	Log(tID model.TaskID, rID model.RunID) (*stdstreams.Log, error)
+	// DeleteRun deletes a run together with its log and search index entries.
+	DeleteRun(tID model.TaskID, rID model.RunID) error
+
+	// AppendAudit appends an entry to the audit log and sets its ID. Entries are never changed
+	// or deleted.
+	AppendAudit(e *model.AuditEntry) error
+	// Audit returns up to count entries of the audit log matching f, latest first.
+	Audit(f AuditFilter, count int) ([]*model.AuditEntry, error)
+	// Lookup returns the runs whose logs contain all of the given search tokens.
+	Lookup(tokens []string) ([]search.Ref, error)
+
+	// Backup writes a consistent copy of the database to w while it stays usable.
+	Backup(w io.Writer) (int64, error)
+	// Export writes all runs and logs to w in the portable export format, see Record.
+	Export(w io.Writer) error
+	// Import adds runs in the portable export format, keeping their IDs.
+	Import(r io.Reader) (int, error)
+
+	Close()
+}
+
+// Open opens or creates the database file using the given storage backend.
+func Open(storage, file string, p *model.Project) (Store, error) {
+	switch storage {
+	case Bolt:
+		return NewAdapter(file, p)
+	case SQLite:
+		return NewSQLiteStore(file, p)
+	}
+	return nil, fmt.Errorf("unknown storage %q", storage)
+}
+
+// Extension returns the file name extension of databases of the given storage backend.
+func Extension(storage string) string {
+	if storage == SQLite {
+		return ".sqlite"
+	}
+	return ".db"
+}
@@ -0,0 +1,187 @@
+// Package diff computes line diffs.
+package diff
+
+// Op is the kind of an Edit.
+type Op int
+
+// Kinds of edits.
+const (
+	Equal  Op = iota // line is in both a and b
+	Delete           // line is only in a
+	Insert           // line is only in b
+)
+
+// An Edit is a line of the diff. A and B are the indexes of the line in a and b, or -1 if the
+// line is not in a or b respectively.
+type Edit struct {
+	Op   Op
+	A, B int
+}
+
+// MaxChanges limits the work done by Lines. If more lines than MaxChanges differ, Lines
+// reports all lines between the common prefix and suffix as changed.
+const MaxChanges = 2000
+
+// Lines returns the shortest list of edits turning a into b, using Myers' algorithm.
+func Lines(a, b []string) []Edit {
+	// common prefix and suffix, typical for logs of the same task
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	var edits []Edit
+	for i := 0; i < pre; i++ {
+		edits = append(edits, Edit{Equal, i, i})
+	}
+
+	middle := myers(a[pre:len(a)-suf], b[pre:len(b)-suf])
+	if middle == nil {
+		for i := pre; i < len(a)-suf; i++ {
+			middle = append(middle, Edit{Delete, i - pre, -1})
+		}
+		for j := pre; j < len(b)-suf; j++ {
+			middle = append(middle, Edit{Insert, -1, j - pre})
+		}
+	}
+	for _, e := range middle {
+		if e.A >= 0 {
+			e.A += pre
+		}
+		if e.B >= 0 {
+			e.B += pre
+		}
+		edits = append(edits, e)
+	}
+
+	for i := 0; i < suf; i++ {
+		edits = append(edits, Edit{Equal, len(a) - suf + i, len(b) - suf + i})
+	}
+	return edits
+}
+
+// myers returns the shortest edit script turning a into b, or nil if it has more than
+// MaxChanges changes. See "An O(ND) Difference Algorithm and Its Variations" by Eugene Myers.
+func myers(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return []Edit{}
+	}
+
+	max := n + m
+	if max > MaxChanges {
+		max = MaxChanges
+	}
+	offset := max + 1
+	v := make([]int, 2*max+3) // v[offset+k] is the furthest x on diagonal k
+
+	// trace[d] holds v[offset-d-1:offset+d+2] before step d
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1] // move down: insert
+			} else {
+				x = v[offset+k-1] + 1 // move right: delete
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(trace, d, n, m)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the trace of myers back from (n, m) to (0, 0).
+func backtrack(trace [][]int, d, n, m int) []Edit {
+	edits := []Edit{}
+	x, y := n, m
+	for ; d > 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+		k := x - y
+
+		var prevK int
+		if k == -d || k != d && at(k-1) < at(k+1) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			edits = append(edits, Edit{Equal, x, y})
+		}
+		if x == prevX {
+			y--
+			edits = append(edits, Edit{Insert, -1, y})
+		} else {
+			x--
+			edits = append(edits, Edit{Delete, x, -1})
+		}
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		edits = append(edits, Edit{Equal, x, y})
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// Hunks groups edits into hunks of changes surrounded by up to context unchanged lines.
+// Unchanged lines further away from changes are left out.
+func Hunks(edits []Edit, context int) [][]Edit {
+	var hunks [][]Edit
+	var cur []Edit
+	lastChange := -1 // index of the last change in edits
+	for i, e := range edits {
+		if e.Op == Equal {
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		if lastChange >= 0 && start <= lastChange+context+1 {
+			cur = append(cur, edits[lastChange+1:i+1]...) // join with the previous hunk
+		} else {
+			if cur != nil {
+				hunks = append(hunks, append(cur, trailing(edits, lastChange, context)...))
+			}
+			cur = append([]Edit(nil), edits[start:i+1]...)
+		}
+		lastChange = i
+	}
+	if cur != nil {
+		hunks = append(hunks, append(cur, trailing(edits, lastChange, context)...))
+	}
+	return hunks
+}
+
+// trailing returns up to context edits following the change at index i.
+func trailing(edits []Edit, i, context int) []Edit {
+	end := i + 1 + context
+	if end > len(edits) {
+		end = len(edits)
+	}
+	return edits[i+1 : end]
+}
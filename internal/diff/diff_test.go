@@ -0,0 +1,69 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+// format renders edits like a unified diff without headers.
+func format(a, b []string, edits []Edit) string {
+	var s strings.Builder
+	for _, e := range edits {
+		switch e.Op {
+		case Equal:
+			s.WriteString(" " + a[e.A])
+		case Delete:
+			s.WriteString("-" + a[e.A])
+		case Insert:
+			s.WriteString("+" + b[e.B])
+		}
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{"Equal", "a b c", "a b c", " a\n b\n c\n"},
+		{"Empty", "", "", ""},
+		{"Insert", "a c", "a b c", " a\n+b\n c\n"},
+		{"Delete", "a b c", "a c", " a\n-b\n c\n"},
+		{"Replace", "a b c", "a x c", " a\n-b\n+x\n c\n"},
+		{"FromEmpty", "", "a b", "+a\n+b\n"},
+		{"Myers", "a b c a b b a", "c b a b a c", "-a\n-b\n c\n+b\n a\n b\n-b\n a\n+c\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := strings.Fields(test.a), strings.Fields(test.b)
+			got := format(a, b, Lines(a, b))
+			if got != test.expected {
+				t.Errorf("Expected:\n%v\ngot:\n%v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestHunks(t *testing.T) {
+	a := strings.Fields("1 2 3 4 5 6 7 8 9 10 11 12")
+	b := strings.Fields("1 2 x 4 5 6 7 8 9 10 11 y")
+
+	hunks := Hunks(Lines(a, b), 2)
+	if len(hunks) != 2 {
+		t.Fatalf("Expected 2 hunks, got: %v", hunks)
+	}
+	if got := format(a, b, hunks[0]); got != " 1\n 2\n-3\n+x\n 4\n 5\n" {
+		t.Errorf("Unexpected first hunk:\n%v", got)
+	}
+	if got := format(a, b, hunks[1]); got != " 10\n 11\n-12\n+y\n" {
+		t.Errorf("Unexpected second hunk:\n%v", got)
+	}
+
+	if hunks := Hunks(Lines(a, b), 4); len(hunks) != 1 {
+		t.Errorf("Expected changes to join into 1 hunk, got: %v", len(hunks))
+	}
+}
@@ -1,7 +1,11 @@
 // Package model contains core domain models.
 package model
 
-import "time"
+import (
+	"sort"
+	"strings"
+	"time"
+)
 
 // RunID represents the task-unique identifier of a run.
 type RunID string
@@ -11,23 +15,280 @@ type TaskID string
 
 // Project represents a project.
 type Project struct {
-	ID    string
+	ID     string
+	Name   string
+	Tasks  []Task
+	Users  []User
+	Agents []Agent
+
+	// TagWindows restrict when runs of tasks with a tag may start, like Task.Windows. The
+	// windows of the task and of each of its tags must all allow a run.
+	TagWindows map[string][]Window
+}
+
+// User represents someone allowed to access the API. Users authenticate with their token.
+type User struct {
+	Name     string
+	Token    string
+	Admin    bool // may view the audit log and declare freezes
+	Override bool // may start runs during freezes and outside maintenance windows
+}
+
+// Agent is a host running tasks assigned by the server, see Task.Target. Agents authenticate
+// with their token.
+type Agent struct {
 	Name  string
-	Tasks []Task
+	Token string
+}
+
+// AgentLabel is the label every agent has in addition to the labels it advertises, with the
+// name of the agent as value.
+const AgentLabel = "agent"
+
+// FormatLabels formats labels of agents or Task.Target as key=value pairs ordered by key.
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
 }
 
 // Task represents a task.
 type Task struct {
-	ID   TaskID
-	Name string
-	Cmd  string
-	Args []string
+	ID          TaskID
+	Name        string
+	Description string   // shown with the task in the web interface
+	Group       string   // tasks of a group are listed together, in order of their first task
+	Tags        []string // labels to filter tasks by
+	Cmd         string
+	Args        []string
+	TTY         bool    // run the process in a pseudo-terminal, merging stdout and stderr
+	TTYSize     TTYSize // window size of the pseudo-terminal, DefaultTTYSize if zero
+
+	// Stdin is written to the standard input of the process. ${name} is replaced by the
+	// value of the run parameter name, other uses of $ are kept as they are.
+	Stdin string
+
+	// Interactive keeps standard input open while the task runs, so operators can answer
+	// prompts. Otherwise standard input is closed after Stdin was written.
+	Interactive bool
+
+	// DiffIgnore are regular expressions matching volatile parts of output lines, like
+	// timestamps, which are ignored when comparing the output of two runs.
+	DiffIgnore []string
+
+	Dir string // working directory of the process, the working directory of optask if empty
+
+	// Artifacts are glob patterns (see filepath.Match) of files relative to Dir that are kept
+	// with the run once it completed. Files written to the directory $OPTASK_ARTIFACTS are
+	// kept, too.
+	Artifacts []string
+
+	// MaxArtifactsSize limits the total size of the artifacts of a run in bytes,
+	// DefaultMaxArtifactsSize if zero. Files exceeding the limit are not kept.
+	MaxArtifactsSize int64
+
+	// KeepRuns is the number of latest runs kept. Older runs are deleted with their logs and
+	// artifacts when a run completes. All runs are kept if zero.
+	KeepRuns int
+
+	// Success decides the outcome of completed runs. By default, runs succeed if they exit
+	// with code 0 and fail otherwise.
+	Success SuccessRules
+
+	// Confirm requires operators to type the task ID before a run starts from the web
+	// interface.
+	Confirm bool
+
+	// Approval makes runs wait until a user other than the one requesting the run approves
+	// it. Unapproved requests expire after ApprovalMinutes, DefaultApprovalMinutes if zero.
+	Approval        bool
+	ApprovalMinutes int
+
+	// Limits restrict the resources available to the process.
+	Limits ResourceLimits
+
+	// Windows restrict when runs may start. If there are allow windows, runs may only start
+	// during one of them. Runs never start during a deny window. See Service.Exec.
+	Windows []Window
+
+	// Target selects the agents running the task by their labels, see AgentLabel. Runs start
+	// on a connected agent having all labels of Target, or on the server if Target is empty.
+	// On agents, Cmd and Dir refer to the host of the agent, and TTY, Interactive, Artifacts,
+	// Limits, RunAs, $OPTASK_ARTIFACTS and $OPTASK_OUTPUT are not supported.
+	Target map[string]string
+
+	// RunAs is the user and group the process runs as. Switching users requires optask to run
+	// as root. The process runs as the user of optask if empty.
+	RunAs Credentials
+}
+
+// Credentials name a Unix user and group by name or numeric ID.
+type Credentials struct {
+	User  string
+	Group string // primary group, the primary group of User if empty
+}
+
+// ResourceLimits restrict the resources of a process. Zero values mean no limit. The rlimits
+// (see setrlimit(2)) apply to the process and each of its children separately, while Memory
+// and CPUQuota apply to all of them together. Memory and CPUQuota require a cgroup v2 directory
+// delegated to optask and are not applied otherwise.
+type ResourceLimits struct {
+	CPUSeconds   uint64  // CPU time, the process is killed when exceeding it (RLIMIT_CPU)
+	AddressSpace uint64  // virtual memory in bytes (RLIMIT_AS)
+	OpenFiles    uint64  // open file descriptors (RLIMIT_NOFILE)
+	Processes    uint64  // processes of the user optask runs as, not just of the task (RLIMIT_NPROC)
+	Memory       uint64  // memory in bytes, the process is killed when exceeding it (memory.max)
+	CPUQuota     float64 // number of CPUs, like 0.5 (cpu.max)
+}
+
+// Window is a weekly recurring period of time.
+type Window struct {
+	Deny     bool     // runs must not start during the window, instead of only during it
+	Days     []string // days the window starts on, like Mon or Sat, every day if empty
+	From, To string   // time of day as HH:MM, To is exclusive and may be on the next day
+	TimeZone string   // IANA name like Europe/Berlin, local time if empty
 }
 
+// Freeze stops all runs of a project until it is lifted, except runs overriding it.
+type Freeze struct {
+	Reason string
+	User   string // name of the user who declared the freeze
+	Since  time.Time
+}
+
+// SuccessRules decide the Outcome of a completed run from its exit code and output. Patterns
+// are regular expressions matched against the lines written to stdout and stderr.
+type SuccessRules struct {
+	ExitCodes        []int    // exit codes of successful runs, 0 if empty
+	WarningExitCodes []int    // exit codes of runs that succeeded with a warning
+	FailurePatterns  []string // runs writing a matching line fail regardless of their exit code
+	WarningPatterns  []string // successful runs writing a matching line get a warning
+}
+
+// DefaultMaxArtifactsSize is the size limit of the artifacts of a run if a task does not
+// specify one.
+const DefaultMaxArtifactsSize = 100 << 20
+
+// DefaultApprovalMinutes is the time in minutes after which unapproved requests of a task
+// expire if the task does not specify one.
+const DefaultApprovalMinutes = 60
+
+// Request is a run of a task requiring approval that waits for it.
+type Request struct {
+	ID      string
+	TaskID  TaskID
+	Params  map[string]string
+	User    string // name of the user requesting the run
+	Created time.Time
+	Expires time.Time
+}
+
+// TTYSize represents the window size of a pseudo-terminal.
+type TTYSize struct {
+	Rows, Cols uint16
+}
+
+// DefaultTTYSize is the window size of pseudo-terminals if a task does not specify one.
+var DefaultTTYSize = TTYSize{Rows: 24, Cols: 80}
+
 // Run represents a run, i.e. an instance of a task.
 type Run struct {
 	ID        RunID
 	Started   time.Time
 	Completed time.Time
 	ExitCode  int
+	Params    map[string]string // parameters passed to the process as environment variables
+	Canceled  bool              // whether the run was canceled before it completed
+	Artifacts []Artifact        // files kept with the run, ordered by name
+	Results   []Result          // results reported by the process, see package results
+	Summary   string            // summary message reported by the process
+	Outcome   Outcome           // decided when the run completed, see SuccessRules
+	Error     string            // why optask failed to start or complete the run, see Error
+	Agent     string            // name of the agent that ran the run, empty for the server
+
+	MaxRSS  int64         // peak resident memory of the process in bytes, 0 if unknown
+	CPUTime time.Duration // user and system CPU time of the process
+
+	// Override is why the task was blocked when the run was started anyway by OverrideBy, see
+	// Task.Windows and Freeze.
+	Override   string
+	OverrideBy string
 }
+
+// Outcome is the result of a completed run.
+type Outcome string
+
+// Outcomes of completed runs.
+const (
+	Succeeded Outcome = "succeeded"
+	Warning   Outcome = "warning" // succeeded, but something needs attention
+	Failed    Outcome = "failed"
+	Canceled  Outcome = "canceled"
+	Error     Outcome = "error" // optask failed to start or complete the run, see Run.Error
+)
+
+// OK reports whether the run did what it should, possibly with a warning.
+func (o Outcome) OK() bool {
+	return o == Succeeded || o == Warning
+}
+
+// DefaultOutcome is the outcome of a run without SuccessRules.
+func DefaultOutcome(exitCode int, canceled bool) Outcome {
+	switch {
+	case canceled:
+		return Canceled
+	case exitCode == 0:
+		return Succeeded
+	}
+	return Failed
+}
+
+// Result is a named value reported by a run, e.g. a metric of a health check.
+type Result struct {
+	Name  string
+	Value string
+}
+
+// Artifact is a file kept with a run.
+type Artifact struct {
+	Name   string // path relative to the artifacts directory of the run, separated by slashes
+	Size   int64
+	SHA256 string // hex encoded checksum
+}
+
+// AuditEntry records an action of an operator, see the Audit constants.
+type AuditEntry struct {
+	ID         uint64 // increasing, set when the entry is appended
+	Time       time.Time
+	User       string // empty if the operator did not authenticate
+	RemoteAddr string
+	Action     string
+	TaskID     TaskID `json:",omitempty"`
+	RunID      RunID  `json:",omitempty"`
+	Detail     string `json:",omitempty"`
+}
+
+// Actions recorded in the audit log.
+const (
+	AuditStart       = "start" // the server started with a configuration
+	AuditExec        = "exec"
+	AuditCancel      = "cancel"
+	AuditRequest     = "request" // a run requiring approval was requested
+	AuditApprove     = "approve"
+	AuditReject      = "reject"
+	AuditExpire      = "expire" // a request was not approved in time
+	AuditFreeze      = "freeze"
+	AuditUnfreeze    = "unfreeze"
+	AuditInput       = "input" // a line of input was sent to a run, the line is not recorded
+	AuditLogin       = "login"
+	AuditLoginFailed = "login-failed"
+	AuditLogout      = "logout"
+	AuditDenied      = "denied" // a request was rejected for a missing token or permission
+	AuditBackup      = "backup"
+	AuditRestore     = "restore"
+	AuditImport      = "import"
+	AuditExport      = "audit-export" // the audit log was exported
+)
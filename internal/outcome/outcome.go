@@ -0,0 +1,94 @@
+// Package outcome decides the outcome of completed runs, see model.SuccessRules.
+package outcome
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+// Rules are compiled model.SuccessRules.
+type Rules struct {
+	ok, warning     map[int]bool
+	failurePatterns []*regexp.Regexp
+	warningPatterns []*regexp.Regexp
+}
+
+// Compile compiles the patterns of s.
+func Compile(s model.SuccessRules) (*Rules, error) {
+	r := &Rules{ok: make(map[int]bool), warning: make(map[int]bool)}
+
+	if len(s.ExitCodes) == 0 {
+		r.ok[0] = true
+	}
+	for _, c := range s.ExitCodes {
+		r.ok[c] = true
+	}
+	for _, c := range s.WarningExitCodes {
+		if r.ok[c] {
+			return nil, fmt.Errorf("exit code %d is both successful and a warning", c)
+		}
+		r.warning[c] = true
+	}
+
+	var err error
+	if r.failurePatterns, err = compileAll(s.FailurePatterns); err != nil {
+		return nil, err
+	}
+	if r.warningPatterns, err = compileAll(s.WarningPatterns); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func compileAll(exprs []string) ([]*regexp.Regexp, error) {
+	ret := make([]*regexp.Regexp, len(exprs))
+	for i, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = re
+	}
+	return ret, nil
+}
+
+// Decide returns the outcome of a run that completed with exitCode and wrote lines.
+func (r *Rules) Decide(exitCode int, canceled bool, lines []stdstreams.Line) model.Outcome {
+	if canceled {
+		return model.Canceled
+	}
+	if r.matches(r.failurePatterns, lines) {
+		return model.Failed
+	}
+
+	switch {
+	case r.warning[exitCode]:
+		return model.Warning
+	case !r.ok[exitCode]:
+		return model.Failed
+	case r.matches(r.warningPatterns, lines):
+		return model.Warning
+	}
+	return model.Succeeded
+}
+
+// matches reports whether any line written to stdout or stderr matches any of patterns.
+func (r *Rules) matches(patterns []*regexp.Regexp, lines []stdstreams.Line) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	for _, l := range lines {
+		if l.Stream != stdstreams.Out && l.Stream != stdstreams.Err {
+			continue
+		}
+		for _, re := range patterns {
+			if re.MatchString(l.Text) {
+				return true
+			}
+		}
+	}
+	return false
+}
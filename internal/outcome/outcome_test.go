@@ -0,0 +1,62 @@
+package outcome
+
+import (
+	"testing"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+func TestDecide(t *testing.T) {
+	rules := model.SuccessRules{
+		ExitCodes:        []int{0, 1},
+		WarningExitCodes: []int{3},
+		FailurePatterns:  []string{`^ERROR`},
+		WarningPatterns:  []string{`deprecated`},
+	}
+
+	tests := []struct {
+		name     string
+		rules    model.SuccessRules
+		exit     int
+		canceled bool
+		stdout   string
+		stderr   string
+		expected model.Outcome
+	}{
+		{"Default", model.SuccessRules{}, 0, false, "ok", "", model.Succeeded},
+		{"DefaultFailure", model.SuccessRules{}, 1, false, "ok", "", model.Failed},
+		{"Canceled", rules, 0, true, "ERROR", "", model.Canceled},
+		{"AcceptedExitCode", rules, 1, false, "nothing to do", "", model.Succeeded},
+		{"WarningExitCode", rules, 3, false, "ok", "", model.Warning},
+		{"OtherExitCode", rules, 2, false, "ok", "", model.Failed},
+		{"FailurePattern", rules, 0, false, "ERROR: disk full", "", model.Failed},
+		{"FailurePatternInStderr", rules, 3, false, "ok", "ERROR", model.Failed},
+		{"WarningPattern", rules, 0, false, "flag is deprecated", "", model.Warning},
+		{"WarningPatternOnFailure", rules, 2, false, "flag is deprecated", "", model.Failed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := Compile(test.rules)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			l := stdstreams.NewLog()
+			l.Stdout().Write([]byte(test.stdout + "\n"))
+			l.Stderr().Write([]byte(test.stderr + "\n"))
+
+			if got := r.Decide(test.exit, test.canceled, l.Lines()); got != test.expected {
+				t.Errorf("Expected %v, got: %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestCompileOverlap(t *testing.T) {
+	_, err := Compile(model.SuccessRules{WarningExitCodes: []int{0}})
+	if err == nil {
+		t.Error("Expected error for exit code 0 being both successful and a warning")
+	}
+}
@@ -0,0 +1,68 @@
+// Package results parses the results tasks report besides their exit code.
+//
+// Tasks report results as lines of the form name=value, either written to the file named by
+// $OPTASK_OUTPUT or to standard output prefixed with Prefix:
+//
+//	echo "disk=83% full" >> "$OPTASK_OUTPUT"
+//	echo "::optask::pending_migrations=12"
+//
+// The name summary sets the summary message of the run instead of a result.
+package results
+
+import (
+	"strings"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+// Prefix marks result lines in the standard output of a task.
+const Prefix = "::optask::"
+
+// SummaryName is the name of the summary message.
+const SummaryName = "summary"
+
+// limits protecting the database from runaway tasks
+const (
+	MaxResults     = 100
+	MaxValueLength = 1024
+)
+
+// A Parser collects results from lines. Later values of a result replace earlier ones,
+// keeping the position of the first.
+type Parser struct {
+	Results []model.Result
+	Summary string
+}
+
+// Line parses a line of the output file. Lines without '=' are ignored.
+func (p *Parser) Line(line string) {
+	name, value, ok := strings.Cut(strings.TrimRight(line, "\r\n"), "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return
+	}
+	if len(value) > MaxValueLength {
+		value = value[:MaxValueLength]
+	}
+
+	if name == SummaryName {
+		p.Summary = value
+		return
+	}
+	for i, r := range p.Results {
+		if r.Name == name {
+			p.Results[i].Value = value
+			return
+		}
+	}
+	if len(p.Results) < MaxResults {
+		p.Results = append(p.Results, model.Result{Name: name, Value: value})
+	}
+}
+
+// LogLine parses a line of standard output, ignoring it unless it starts with Prefix.
+func (p *Parser) LogLine(line string) {
+	if strings.HasPrefix(line, Prefix) {
+		p.Line(line[len(Prefix):])
+	}
+}
@@ -0,0 +1,41 @@
+package results
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParser(t *testing.T) {
+	var p Parser
+	p.Line("disk=83% full\n")
+	p.Line("no separator")
+	p.Line("=no name")
+	p.LogLine("pending=1")
+	p.LogLine(Prefix + "pending=12")
+	p.LogLine(Prefix + "summary=all good")
+	p.Line("disk=84% full")
+	p.Line("empty=")
+
+	got := fmt.Sprint(p.Results)
+	expected := "[{disk 84% full} {pending 12} {empty }]"
+	if got != expected {
+		t.Errorf("Expected results %v, got: %v", expected, got)
+	}
+	if p.Summary != "all good" {
+		t.Errorf("Expected summary \"all good\", got: %q", p.Summary)
+	}
+}
+
+func TestParserLimits(t *testing.T) {
+	var p Parser
+	for i := 0; i < MaxResults+1; i++ {
+		p.Line(fmt.Sprintf("r%d=%v", i, strings.Repeat("x", MaxValueLength+1)))
+	}
+	if len(p.Results) != MaxResults {
+		t.Errorf("Expected %d results, got: %d", MaxResults, len(p.Results))
+	}
+	if len(p.Results[0].Value) != MaxValueLength {
+		t.Errorf("Expected value of %d bytes, got: %d", MaxValueLength, len(p.Results[0].Value))
+	}
+}
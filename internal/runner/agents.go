@@ -0,0 +1,372 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ngrash/optask/internal/api"
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/outcome"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+const agentQueue = 16 // messages buffered for a connected agent
+
+// agentSendTimeout limits the time to wait for space in the queue of an agent.
+var agentSendTimeout = 5 * time.Second
+
+// agentConn is a connection of an agent, see ConnectAgent.
+type agentConn struct {
+	name     string
+	labels   map[string]string
+	since    time.Time // when the connection was made
+	lastSeen time.Time
+	closed   time.Time // zero while connected
+	msgs     chan api.AgentMessage
+	done     chan struct{} // closed when the connection is closed
+}
+
+// runKey identifies a run across tasks.
+type runKey struct {
+	task model.TaskID
+	run  model.RunID
+}
+
+// remoteRun is a run assigned to an agent.
+type remoteRun struct {
+	agent     string
+	reporting bool // whether the agent started to report the run, see ReportRun
+	canceled  bool
+	complete  func(d api.AgentDone, err error)
+}
+
+// AgentConn is a connection of an agent receiving the runs assigned to it.
+type AgentConn struct {
+	s *Service
+	c *agentConn
+}
+
+// Messages returns the messages to send to the agent.
+func (a *AgentConn) Messages() <-chan api.AgentMessage {
+	return a.c.msgs
+}
+
+// Done is closed when the connection is closed or replaced by a new connection of the agent.
+func (a *AgentConn) Done() <-chan struct{} {
+	return a.c.done
+}
+
+// Seen records that the agent is alive, e.g. after a message was sent to it.
+func (a *AgentConn) Seen() {
+	a.s.agentsMu.Lock()
+	a.c.lastSeen = time.Now()
+	a.s.agentsMu.Unlock()
+}
+
+// Close closes the connection. Runs assigned to the agent that it did not start to report
+// complete with an error. Closing a connection replaced by ConnectAgent has no effect.
+func (a *AgentConn) Close() {
+	s, c := a.s, a.c
+	s.agentsMu.Lock()
+	if !c.closed.IsZero() {
+		s.agentsMu.Unlock()
+		return
+	}
+	c.close()
+
+	var lost []*remoteRun
+	for _, rr := range s.remote {
+		if rr.agent == c.name && !rr.reporting {
+			lost = append(lost, rr)
+		}
+	}
+	s.agentsMu.Unlock()
+
+	for _, rr := range lost {
+		rr.complete(api.AgentDone{ExitCode: -1}, fmt.Errorf("agent %v disconnected before starting the run", c.name))
+	}
+}
+
+// close marks c as closed. The caller must hold agentsMu.
+func (c *agentConn) close() {
+	c.closed = time.Now()
+	close(c.done)
+}
+
+// ConnectAgent registers a connection of the agent name advertising labels, replacing an
+// earlier connection of the agent. Every agent has the label model.AgentLabel. Messages not
+// yet sent on the earlier connection move to the new one. Runs sent on it that the agent did
+// not start to report complete with an error, since the agent might not have received them.
+func (s *Service) ConnectAgent(name string, labels map[string]string) *AgentConn {
+	ls := map[string]string{model.AgentLabel: name}
+	for k, v := range labels {
+		if k != model.AgentLabel {
+			ls[k] = v
+		}
+	}
+
+	now := time.Now()
+	c := &agentConn{
+		name:     name,
+		labels:   ls,
+		since:    now,
+		lastSeen: now,
+		msgs:     make(chan api.AgentMessage, agentQueue),
+		done:     make(chan struct{}),
+	}
+
+	var lost []*remoteRun
+	s.agentsMu.Lock()
+	if old := s.agents[name]; old != nil && old.closed.IsZero() {
+		old.close()
+		queued := make(map[runKey]bool)
+		for pending := true; pending; {
+			select {
+			case m := <-old.msgs:
+				if m.Run != nil {
+					queued[runKey{m.Run.TaskID, m.Run.RunID}] = true
+				}
+				c.msgs <- m // fits, both queues have the same size
+			default:
+				pending = false
+			}
+		}
+		for key, rr := range s.remote {
+			if rr.agent == name && !rr.reporting && !queued[key] {
+				lost = append(lost, rr)
+			}
+		}
+	}
+	s.agents[name] = c
+	s.agentsMu.Unlock()
+
+	for _, rr := range lost {
+		rr.complete(api.AgentDone{ExitCode: -1}, fmt.Errorf("agent %v reconnected before starting the run", name))
+	}
+	return &AgentConn{s, c}
+}
+
+// Agents returns the state of the configured agents in the order of the configuration.
+func (s *Service) Agents() []api.Agent {
+	s.agentsMu.Lock()
+	defer s.agentsMu.Unlock()
+
+	runs := make(map[string]int)
+	for _, rr := range s.remote {
+		runs[rr.agent]++
+	}
+
+	var agents []api.Agent
+	for _, a := range s.project.Agents {
+		info := api.Agent{Name: a.Name, Runs: runs[a.Name]}
+		if c := s.agents[a.Name]; c != nil {
+			info.Labels = c.labels
+			info.Connected = c.closed.IsZero()
+			info.LastSeen = c.lastSeen
+			info.Since = c.since
+			if !info.Connected {
+				info.Since = c.closed
+			}
+		}
+		agents = append(agents, info)
+	}
+	return agents
+}
+
+// matches reports whether the labels of c include all labels of target.
+func (c *agentConn) matches(target map[string]string) bool {
+	for k, v := range target {
+		if c.labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// startRemote assigns the run r of task to a connected agent matching the target of the task,
+// preferring agents with fewer runs.
+func (s *Service) startRemote(task model.Task, r *model.Run, rules *outcome.Rules, env []string, log *stdstreams.Log) (model.RunID, error) {
+	key := runKey{task.ID, r.ID}
+	rr := &remoteRun{}
+	rr.complete = func(d api.AgentDone, err error) {
+		s.agentsMu.Lock()
+		if s.remote[key] != rr {
+			s.agentsMu.Unlock()
+			return // completed already
+		}
+		delete(s.remote, key)
+		canceled := rr.canceled
+		s.agentsMu.Unlock()
+
+		if err == nil && d.Error != "" {
+			err = errors.New(d.Error)
+		}
+		r.Completed = time.Now()
+		r.ExitCode = d.ExitCode
+		r.Canceled = canceled
+		r.CPUTime = d.CPUTime
+		r.MaxRSS = d.MaxRSS
+		r.Outcome = rules.Decide(d.ExitCode, canceled, log.Lines())
+		if err != nil {
+			r.Outcome, r.Error = model.Error, err.Error()
+		}
+		r.Results, r.Summary = readResults(nil, log)
+		s.complete(task, r, log)
+	}
+
+	cancel := func() error {
+		s.agentsMu.Lock()
+		if s.remote[key] != rr {
+			s.agentsMu.Unlock()
+			return nil
+		}
+		rr.canceled = true
+		c := s.agents[rr.agent]
+		if c != nil && !c.closed.IsZero() {
+			c = nil
+		}
+		reporting := rr.reporting
+		s.agentsMu.Unlock()
+
+		if !reporting {
+			// the agent ignores the run, but might have received it already
+			if c != nil {
+				select {
+				case c.msgs <- api.AgentMessage{Cancel: &api.AgentRun{TaskID: task.ID, RunID: r.ID}}:
+				default:
+				}
+			}
+			rr.complete(api.AgentDone{ExitCode: -1}, nil)
+			return nil
+		}
+
+		if c != nil {
+			select {
+			case c.msgs <- api.AgentMessage{Cancel: &api.AgentRun{TaskID: task.ID, RunID: r.ID}}:
+				return nil
+			case <-c.done:
+			case <-time.After(agentSendTimeout):
+			}
+		}
+		s.agentsMu.Lock()
+		rr.canceled = false
+		s.agentsMu.Unlock()
+		return fmt.Errorf("agent %v did not receive the request to cancel the run", rr.agent)
+	}
+
+	s.agentsMu.Lock()
+	var conn *agentConn
+	runs := make(map[string]int)
+	for _, other := range s.remote {
+		runs[other.agent]++
+	}
+	for _, c := range s.agents {
+		if !c.closed.IsZero() || !c.matches(task.Target) {
+			continue
+		}
+		if conn == nil || runs[c.name] < runs[conn.name] || runs[c.name] == runs[conn.name] && c.name < conn.name {
+			conn = c
+		}
+	}
+	if conn == nil {
+		s.agentsMu.Unlock()
+		return s.failRun(task, r, log, fmt.Errorf("no connected agent matches target %v", model.FormatLabels(task.Target)))
+	}
+	rr.agent = conn.name
+	r.Agent = conn.name
+
+	s.mu.Lock()
+	s.runs[task.ID][r.ID] = runData{r, log, nil, cancel}
+	s.mu.Unlock()
+
+	msg := api.AgentMessage{Run: &api.AgentRun{
+		TaskID: task.ID,
+		RunID:  r.ID,
+		Cmd:    task.Cmd,
+		Args:   task.Args,
+		Dir:    task.Dir,
+		Env:    env,
+		Stdin:  expandParams(task.Stdin, r.Params),
+	}}
+	select {
+	case conn.msgs <- msg:
+		s.remote[key] = rr
+		s.agentsMu.Unlock()
+	default:
+		s.agentsMu.Unlock()
+		return s.failRun(task, r, log, fmt.Errorf("agent %v is not receiving runs", conn.name))
+	}
+	return r.ID, nil
+}
+
+// ReportRun records the output and result of a run assigned to agent, reading the reports
+// the agent streams with next up to the report with Done. The run completes with an error if
+// next fails before.
+func (s *Service) ReportRun(agent string, tID model.TaskID, rID model.RunID, next func() (*api.AgentReport, error)) error {
+	key := runKey{tID, rID}
+	s.agentsMu.Lock()
+	rr := s.remote[key]
+	if rr == nil || rr.agent != agent {
+		s.agentsMu.Unlock()
+		return fmt.Errorf("run %v of task %v is not assigned to agent %v", rID, tID, agent)
+	}
+	if rr.reporting {
+		s.agentsMu.Unlock()
+		return fmt.Errorf("run %v of task %v is reported already", rID, tID)
+	}
+	rr.reporting = true
+	s.agentsMu.Unlock()
+
+	s.mu.Lock()
+	log := s.runs[tID][rID].l
+	s.mu.Unlock()
+
+	for {
+		rep, err := next()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			rr.complete(api.AgentDone{ExitCode: -1}, fmt.Errorf("agent %v stopped reporting the run: %w", agent, err))
+			return err
+		}
+		s.agentSeen(agent)
+
+		switch {
+		case rep.Line != nil:
+			if !validLine(*rep.Line) {
+				err := fmt.Errorf("agent %v sent an invalid line", agent)
+				rr.complete(api.AgentDone{ExitCode: -1}, err)
+				return err
+			}
+			log.Append(*rep.Line)
+		case rep.Done != nil:
+			rr.complete(*rep.Done, nil)
+			return nil
+		}
+	}
+}
+
+// validLine reports whether l is a line of standard output or error with valid styles. Lines
+// from agents are checked before they are shown.
+func validLine(l stdstreams.Line) bool {
+	if l.Stream != stdstreams.Out && l.Stream != stdstreams.Err {
+		return false
+	}
+	for _, span := range l.Spans {
+		if !span.Style.Valid() {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Service) agentSeen(name string) {
+	s.agentsMu.Lock()
+	if c := s.agents[name]; c != nil {
+		c.lastSeen = time.Now()
+	}
+	s.agentsMu.Unlock()
+}
@@ -0,0 +1,376 @@
+package runner
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ngrash/optask/internal/api"
+	"github.com/ngrash/optask/internal/db"
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+func newAgentService(t *testing.T) *Service {
+	dir, err := ioutil.TempDir("", "optask-agents-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	p := &model.Project{
+		ID:   "p",
+		Name: "P",
+		Tasks: []model.Task{
+			{ID: "db", Name: "DB", Cmd: "true", Target: map[string]string{"role": "db"}},
+			{ID: "web1", Name: "Web1", Cmd: "true", Target: map[string]string{model.AgentLabel: "web1"}},
+			{ID: "cache", Name: "Cache", Cmd: "true", Target: map[string]string{"role": "cache"}},
+		},
+		Agents: []model.Agent{{Name: "db1", Token: "a1"}, {Name: "db2", Token: "a2"}, {Name: "web1", Token: "a3"}},
+	}
+	return NewService(p, dir, db.Bolt)
+}
+
+// receive returns the next message to the agent of c, failing if there is none.
+func receive(t *testing.T, c *AgentConn) api.AgentMessage {
+	t.Helper()
+	select {
+	case m := <-c.Messages():
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("Expected a message")
+		return api.AgentMessage{}
+	}
+}
+
+func expectNoMessage(t *testing.T, c *AgentConn) {
+	t.Helper()
+	select {
+	case m := <-c.Messages():
+		t.Errorf("Unexpected message: %+v", m)
+	default:
+	}
+}
+
+// reports returns a next function for Service.ReportRun reading from ch. A closed ch ends
+// the stream with err.
+func reports(ch <-chan api.AgentReport, err error) func() (*api.AgentReport, error) {
+	return func() (*api.AgentReport, error) {
+		rep, ok := <-ch
+		if !ok {
+			return nil, err
+		}
+		return &rep, nil
+	}
+}
+
+func completedRun(t *testing.T, s *Service, tID model.TaskID, rID model.RunID) *model.Run {
+	t.Helper()
+	if s.IsRunning(tID, rID) {
+		t.Fatalf("Expected run %v of %v to be completed", rID, tID)
+	}
+	r, err := s.Run(tID, rID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestAgentAssignment(t *testing.T) {
+	s := newAgentService(t)
+	db1 := s.ConnectAgent("db1", map[string]string{"role": "db"})
+	db2 := s.ConnectAgent("db2", map[string]string{"role": "db", "zone": "b"})
+	web1 := s.ConnectAgent("web1", map[string]string{"role": "web", model.AgentLabel: "other"})
+
+	// runs go to the matching agent with the fewest runs, ties are broken by name
+	for _, expected := range []*AgentConn{db1, db2, db1} {
+		rID, err := s.Exec("db", map[string]string{"x": "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := receive(t, expected)
+		if m.Run == nil || m.Run.TaskID != "db" || m.Run.RunID != rID || m.Run.Cmd != "true" {
+			t.Errorf("Unexpected message: %+v", m)
+		}
+		if len(m.Run.Env) != 1 || m.Run.Env[0] != "OPTASK_PARAM_X=1" {
+			t.Errorf("Unexpected environment: %v", m.Run.Env)
+		}
+	}
+	expectNoMessage(t, db2)
+
+	// the agent label cannot be overridden
+	if _, err := s.Exec("web1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if m := receive(t, web1); m.Run == nil || m.Run.TaskID != "web1" {
+		t.Errorf("Unexpected message: %+v", m)
+	}
+
+	runs := make(map[string]int)
+	for _, a := range s.Agents() {
+		if !a.Connected {
+			t.Errorf("Expected agent %v to be connected", a.Name)
+		}
+		runs[a.Name] = a.Runs
+	}
+	if runs["db1"] != 2 || runs["db2"] != 1 || runs["web1"] != 1 {
+		t.Errorf("Unexpected runs per agent: %v", runs)
+	}
+
+	rID, err := s.Exec("cache", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := completedRun(t, s, "cache", rID)
+	if r.Outcome != model.Error || !strings.Contains(r.Error, "no connected agent matches target role=cache") {
+		t.Errorf("Unexpected run: %+v", r)
+	}
+}
+
+func TestAgentReport(t *testing.T) {
+	s := newAgentService(t)
+	conn := s.ConnectAgent("db1", map[string]string{"role": "db"})
+	rID, err := s.Exec("db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, conn)
+
+	if err := s.ReportRun("db2", "db", rID, reports(nil, nil)); err == nil {
+		t.Error("Expected an error reporting the run of another agent")
+	}
+
+	ch := make(chan api.AgentReport, 3)
+	ch <- api.AgentReport{Line: &stdstreams.Line{Stream: stdstreams.Out, Text: "hello"}}
+	ch <- api.AgentReport{Line: &stdstreams.Line{Stream: stdstreams.Err, Text: "oops"}}
+	ch <- api.AgentReport{Done: &api.AgentDone{ExitCode: 3, MaxRSS: 1024}}
+	if err := s.ReportRun("db1", "db", rID, reports(ch, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := completedRun(t, s, "db", rID)
+	if r.Agent != "db1" || r.ExitCode != 3 || r.Outcome != model.Failed || r.MaxRSS != 1024 {
+		t.Errorf("Unexpected run: %+v", r)
+	}
+	log, err := s.StdStreams("db", rID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := log.Lines(); len(lines) != 2 || lines[0].Text != "hello" || lines[1].Stream != stdstreams.Err {
+		t.Errorf("Unexpected lines: %+v", lines)
+	}
+
+	if err := s.ReportRun("db1", "db", rID, reports(nil, nil)); err == nil {
+		t.Error("Expected an error reporting a completed run")
+	}
+}
+
+func TestAgentReportInvalid(t *testing.T) {
+	s := newAgentService(t)
+	conn := s.ConnectAgent("db1", map[string]string{"role": "db"})
+
+	lines := []stdstreams.Line{
+		{Stream: stdstreams.In, Text: "input"},
+		{Stream: stdstreams.Out, Text: "x", Spans: []stdstreams.Span{{Text: "x", Style: stdstreams.Style{FG: `1"><b>`}}}},
+	}
+	for _, line := range lines {
+		rID, err := s.Exec("db", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		receive(t, conn)
+
+		ch := make(chan api.AgentReport, 1)
+		ch <- api.AgentReport{Line: &line}
+		if err := s.ReportRun("db1", "db", rID, reports(ch, nil)); err == nil {
+			t.Errorf("Expected an error reporting %+v", line)
+		}
+		if r := completedRun(t, s, "db", rID); r.Outcome != model.Error || !strings.Contains(r.Error, "invalid line") {
+			t.Errorf("Unexpected run: %+v", r)
+		}
+	}
+}
+
+func TestAgentStopsReporting(t *testing.T) {
+	s := newAgentService(t)
+	conn := s.ConnectAgent("db1", map[string]string{"role": "db"})
+	rID, err := s.Exec("db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, conn)
+
+	ch := make(chan api.AgentReport)
+	close(ch)
+	broken := errors.New("connection reset")
+	if err := s.ReportRun("db1", "db", rID, reports(ch, broken)); err != broken {
+		t.Errorf("Expected %v, got: %v", broken, err)
+	}
+	r := completedRun(t, s, "db", rID)
+	if r.Outcome != model.Error || !strings.Contains(r.Error, "agent db1 stopped reporting the run: connection reset") {
+		t.Errorf("Unexpected run: %+v", r)
+	}
+}
+
+func TestAgentReconnect(t *testing.T) {
+	s := newAgentService(t)
+	old := s.ConnectAgent("db1", map[string]string{"role": "db"})
+	rID, err := s.Exec("db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pending messages move to the new connection
+	conn := s.ConnectAgent("db1", map[string]string{"role": "db", "zone": "a"})
+	select {
+	case <-old.Done():
+	default:
+		t.Error("Expected the old connection to be done")
+	}
+	if m := receive(t, conn); m.Run == nil || m.Run.RunID != rID {
+		t.Errorf("Unexpected message: %+v", m)
+	}
+	old.Close()
+	if !s.IsRunning("db", rID) {
+		t.Fatal("Expected the run to survive closing the replaced connection")
+	}
+	if a := s.Agents()[0]; !a.Connected || a.Labels["zone"] != "a" {
+		t.Errorf("Unexpected agent: %+v", a)
+	}
+
+	conn.Close()
+	r := completedRun(t, s, "db", rID)
+	if r.Outcome != model.Error || !strings.Contains(r.Error, "agent db1 disconnected before starting the run") {
+		t.Errorf("Unexpected run: %+v", r)
+	}
+	if a := s.Agents()[0]; a.Connected || a.Runs != 0 {
+		t.Errorf("Unexpected agent: %+v", a)
+	}
+}
+
+// TestAgentReconnectSent replaces a connection after runs were sent on it.
+func TestAgentReconnectSent(t *testing.T) {
+	s := newAgentService(t)
+	old := s.ConnectAgent("db1", map[string]string{"role": "db"})
+	sent, err := s.Exec("db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, old)
+	reporting, err := s.Exec("db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, old)
+
+	ch := make(chan api.AgentReport)
+	reported := make(chan error)
+	go func() { reported <- s.ReportRun("db1", "db", reporting, reports(ch, nil)) }()
+	ch <- api.AgentReport{Line: &stdstreams.Line{Stream: stdstreams.Out, Text: "started"}}
+
+	// the agent might not have received the run it did not report yet
+	conn := s.ConnectAgent("db1", map[string]string{"role": "db"})
+	r := completedRun(t, s, "db", sent)
+	if r.Outcome != model.Error || !strings.Contains(r.Error, "agent db1 reconnected before starting the run") {
+		t.Errorf("Unexpected run: %+v", r)
+	}
+	expectNoMessage(t, conn)
+
+	// reports do not depend on the connection
+	if !s.IsRunning("db", reporting) {
+		t.Fatal("Expected the reported run to survive the reconnect")
+	}
+	ch <- api.AgentReport{Done: &api.AgentDone{ExitCode: 0}}
+	if err := <-reported; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r := completedRun(t, s, "db", reporting); r.Outcome != model.Succeeded {
+		t.Errorf("Unexpected run: %+v", r)
+	}
+}
+
+func TestAgentCancel(t *testing.T) {
+	s := newAgentService(t)
+	conn := s.ConnectAgent("db1", map[string]string{"role": "db"})
+
+	// not reporting yet, the run completes immediately
+	rID, err := s.Exec("db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, conn)
+	if err := s.Cancel("db", rID); err != nil {
+		t.Fatal(err)
+	}
+	if m := receive(t, conn); m.Cancel == nil || m.Cancel.RunID != rID {
+		t.Errorf("Unexpected message: %+v", m)
+	}
+	if r := completedRun(t, s, "db", rID); r.Outcome != model.Canceled {
+		t.Errorf("Unexpected run: %+v", r)
+	}
+
+	// reporting, the run completes when the agent reports it
+	rID, err = s.Exec("db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, conn)
+	ch := make(chan api.AgentReport)
+	reported := make(chan error)
+	go func() { reported <- s.ReportRun("db1", "db", rID, reports(ch, nil)) }()
+	ch <- api.AgentReport{Line: &stdstreams.Line{Stream: stdstreams.Out, Text: "started"}}
+
+	if err := s.Cancel("db", rID); err != nil {
+		t.Fatal(err)
+	}
+	if m := receive(t, conn); m.Cancel == nil || m.Cancel.RunID != rID {
+		t.Errorf("Unexpected message: %+v", m)
+	}
+	if !s.IsRunning("db", rID) {
+		t.Fatal("Expected the run to wait for the agent")
+	}
+	ch <- api.AgentReport{Done: &api.AgentDone{ExitCode: 143}}
+	if err := <-reported; err != nil {
+		t.Fatal(err)
+	}
+	if r := completedRun(t, s, "db", rID); r.Outcome != model.Canceled || r.ExitCode != 143 {
+		t.Errorf("Unexpected run: %+v", r)
+	}
+}
+
+func TestAgentCancelQueueFull(t *testing.T) {
+	defer func(d time.Duration) { agentSendTimeout = d }(agentSendTimeout)
+	agentSendTimeout = 10 * time.Millisecond
+
+	s := newAgentService(t)
+	conn := s.ConnectAgent("db1", map[string]string{"role": "db"})
+	rID, err := s.Exec("db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, conn)
+	ch := make(chan api.AgentReport)
+	reported := make(chan error)
+	go func() { reported <- s.ReportRun("db1", "db", rID, reports(ch, nil)) }()
+	ch <- api.AgentReport{Line: &stdstreams.Line{Stream: stdstreams.Out, Text: "started"}}
+
+	for i := 0; i < agentQueue; i++ {
+		if _, err := s.Exec("db", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Cancel("db", rID); err == nil || !strings.Contains(err.Error(), "did not receive") {
+		t.Errorf("Expected an error canceling the run, got: %v", err)
+	}
+
+	ch <- api.AgentReport{Done: &api.AgentDone{ExitCode: 0}}
+	if err := <-reported; err != nil {
+		t.Fatal(err)
+	}
+	if r := completedRun(t, s, "db", rID); r.Outcome != model.Succeeded || r.Canceled {
+		t.Errorf("Expected the run not to be canceled: %+v", r)
+	}
+}
@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+// RequestExec requests a run of a task requiring approval on behalf of a user. The run starts
+// once another user approves the request with Approve. Requests are kept in memory only and do
+// not survive a restart.
+func (s *Service) RequestExec(tID model.TaskID, params map[string]string, user string) (*model.Request, error) {
+	task, err := s.Task(tID)
+	if err != nil {
+		return nil, err
+	}
+	if !task.Approval {
+		return nil, fmt.Errorf("task %v does not require approval", tID)
+	}
+	if err := checkParams(params); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	minutes := task.ApprovalMinutes
+	if minutes == 0 {
+		minutes = model.DefaultApprovalMinutes
+	}
+	now := time.Now()
+	req := &model.Request{
+		ID:      hex.EncodeToString(id),
+		TaskID:  tID,
+		Params:  params,
+		User:    user,
+		Created: now,
+		Expires: now.Add(time.Duration(minutes) * time.Minute),
+	}
+
+	s.mu.Lock()
+	s.requests[req.ID] = req
+	s.mu.Unlock()
+
+	return req, nil
+}
+
+// Requests returns the requests waiting for approval, oldest first.
+func (s *Service) Requests() []*model.Request {
+	s.mu.Lock()
+	expired := s.expire()
+	reqs := make([]*model.Request, 0, len(s.requests))
+	for _, req := range s.requests {
+		reqs = append(reqs, req)
+	}
+	s.mu.Unlock()
+
+	s.auditExpired(expired)
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].Created.Before(reqs[j].Created) })
+	return reqs
+}
+
+// Approve starts the run of a request on behalf of user, who must not be the user who
+// requested it. Like with ExecOverride, override starts the run even if the task is blocked.
+// If the request was approved but the run failed to start, the request is returned with the
+// error.
+func (s *Service) Approve(id, user string, override bool) (*model.Request, model.RunID, error) {
+	var task model.Task
+	var r model.Run
+	req, err := s.take(id, func(req *model.Request) error {
+		if req.User == user {
+			return errors.New("requests must be approved by another user")
+		}
+		var err error
+		if task, err = s.Task(req.TaskID); err != nil {
+			return err
+		}
+		r.Params = req.Params
+		return s.allow(task, &r, user, override)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	rID, err := s.start(task, r)
+	return req, rID, err
+}
+
+// Reject discards a request. Users may reject their own requests to withdraw them.
+func (s *Service) Reject(id string) (*model.Request, error) {
+	return s.take(id, func(*model.Request) error { return nil })
+}
+
+// take removes and returns the request id if check accepts it.
+func (s *Service) take(id string, check func(*model.Request) error) (*model.Request, error) {
+	s.mu.Lock()
+	expired := s.expire()
+	req, ok := s.requests[id]
+	var err error
+	if !ok {
+		err = fmt.Errorf("no request with ID %v waits for approval", id)
+	} else if err = check(req); err != nil {
+		req = nil
+	} else {
+		delete(s.requests, id)
+	}
+	s.mu.Unlock()
+
+	s.auditExpired(expired)
+	return req, err
+}
+
+// expire removes and returns expired requests. The caller must hold s.mu.
+func (s *Service) expire() []*model.Request {
+	var expired []*model.Request
+	now := time.Now()
+	for id, req := range s.requests {
+		if !now.Before(req.Expires) {
+			delete(s.requests, id)
+			expired = append(expired, req)
+		}
+	}
+	return expired
+}
+
+func (s *Service) auditExpired(reqs []*model.Request) {
+	for _, req := range reqs {
+		e := &model.AuditEntry{
+			User:   req.User,
+			Action: model.AuditExpire,
+			TaskID: req.TaskID,
+			Detail: "request " + req.ID + " expired " + req.Expires.Format(time.RFC3339),
+		}
+		if err := s.RecordAudit(e); err != nil {
+			log.Printf("recording expired request in audit log: %v", err)
+		}
+	}
+}
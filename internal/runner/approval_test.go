@@ -0,0 +1,142 @@
+package runner
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ngrash/optask/internal/db"
+	"github.com/ngrash/optask/internal/model"
+)
+
+// newTaskService returns a Service for a project with the given tasks.
+func newTaskService(t *testing.T, tasks ...model.Task) *Service {
+	dir, err := ioutil.TempDir("", "optask-runner-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return NewService(&model.Project{ID: "p", Name: "P", Tasks: tasks}, dir, db.Bolt)
+}
+
+// waitCompleted waits until a local run completed and returns it.
+func waitCompleted(t *testing.T, s *Service, tID model.TaskID, rID model.RunID) *model.Run {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); s.IsRunning(tID, rID); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for run %v of %v", rID, tID)
+		}
+	}
+	return completedRun(t, s, tID, rID)
+}
+
+func newApprovalService(t *testing.T) *Service {
+	return newTaskService(t,
+		model.Task{ID: "deploy", Name: "Deploy", Cmd: "true", Approval: true},
+		model.Task{ID: "plain", Name: "Plain", Cmd: "true"},
+	)
+}
+
+func TestApprove(t *testing.T) {
+	s := newApprovalService(t)
+
+	if _, err := s.RequestExec("plain", nil, "alice"); err == nil {
+		t.Error("Expected an error requesting a task without approval")
+	}
+
+	req, err := s.RequestExec("deploy", map[string]string{"x": "1"}, "alice")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reqs := s.Requests(); len(reqs) != 1 || reqs[0].ID != req.ID {
+		t.Fatalf("Expected the request to wait for approval, got: %v", reqs)
+	}
+
+	if _, _, err := s.Approve(req.ID, "alice", false); err == nil || !strings.Contains(err.Error(), "another user") {
+		t.Errorf("Expected an error approving an own request, got: %v", err)
+	}
+	if reqs := s.Requests(); len(reqs) != 1 {
+		t.Fatalf("Expected the request to still wait for approval, got: %v", reqs)
+	}
+
+	approved, rID, err := s.Approve(req.ID, "bob", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if approved.ID != req.ID {
+		t.Errorf("Expected request %v, got: %v", req.ID, approved.ID)
+	}
+	if r := waitCompleted(t, s, "deploy", rID); r.Outcome != model.Succeeded || r.Params["x"] != "1" {
+		t.Errorf("Unexpected run: %+v", r)
+	}
+
+	if _, _, err := s.Approve(req.ID, "carol", false); err == nil {
+		t.Error("Expected an error approving a request twice")
+	}
+	if reqs := s.Requests(); len(reqs) != 0 {
+		t.Errorf("Expected no requests, got: %v", reqs)
+	}
+}
+
+func TestApproveBlocked(t *testing.T) {
+	s := newApprovalService(t)
+	if err := s.Freeze(model.Freeze{Reason: "release", User: "carol"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	req, err := s.RequestExec("deploy", nil, "alice")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var blocked *BlockedError
+	if _, _, err := s.Approve(req.ID, "bob", false); !errors.As(err, &blocked) {
+		t.Fatalf("Expected a *BlockedError, got: %v", err)
+	}
+	if reqs := s.Requests(); len(reqs) != 1 {
+		t.Fatalf("Expected the blocked request to still wait for approval, got: %v", reqs)
+	}
+
+	_, rID, err := s.Approve(req.ID, "bob", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	r := waitCompleted(t, s, "deploy", rID)
+	if r.Override != "frozen: release" || r.OverrideBy != "bob" {
+		t.Errorf("Expected the override to be recorded, got: %+v", r)
+	}
+}
+
+func TestRequestExpire(t *testing.T) {
+	s := newApprovalService(t)
+	req, err := s.RequestExec("deploy", nil, "alice")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d := time.Until(req.Expires); d <= time.Duration(model.DefaultApprovalMinutes-1)*time.Minute || d > time.Duration(model.DefaultApprovalMinutes)*time.Minute {
+		t.Errorf("Expected the request to expire after the default time, got: %v", d)
+	}
+
+	s.mu.Lock()
+	req.Expires = time.Now()
+	s.mu.Unlock()
+
+	if reqs := s.Requests(); len(reqs) != 0 {
+		t.Errorf("Expected the request to expire, got: %v", reqs)
+	}
+	if _, _, err := s.Approve(req.ID, "bob", false); err == nil {
+		t.Error("Expected an error approving an expired request")
+	}
+
+	entries, err := s.AuditLog(db.AuditFilter{Action: model.AuditExpire}, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 1 || entries[0].User != "alice" || entries[0].TaskID != "deploy" || !strings.Contains(entries[0].Detail, req.ID) {
+		t.Errorf("Expected one audit entry for the expired request, got: %+v", entries)
+	}
+}
@@ -0,0 +1,228 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+// ArtifactsDir returns the directory the artifacts of a run are kept in. It is passed to the
+// process as $OPTASK_ARTIFACTS.
+func (s *Service) ArtifactsDir(tID model.TaskID, rID model.RunID) string {
+	return filepath.Join(s.dataDir, "artifacts", s.project.ID, string(tID), string(rID))
+}
+
+// Artifact returns the path of the artifact name of a run.
+func (s *Service) Artifact(tID model.TaskID, rID model.RunID, name string) (string, error) {
+	r, err := s.Run(tID, rID)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range r.Artifacts {
+		if a.Name == name {
+			return filepath.Join(s.ArtifactsDir(tID, rID), filepath.FromSlash(name)), nil
+		}
+	}
+	return "", fmt.Errorf("run %v has no artifact %q", rID, name)
+}
+
+// collectArtifacts keeps the files the process wrote to its artifacts directory src and copies
+// the files matching the artifact patterns of the task to dir, up to the size limit of the task.
+// If src is not dir, as for processes running as another user, the files in src are copied to
+// dir and src is removed. Files that are not kept are reported in log.
+func collectArtifacts(task model.Task, dir, src string, log *stdstreams.Log) []model.Artifact {
+	max := task.MaxArtifactsSize
+	if max == 0 {
+		max = model.DefaultMaxArtifactsSize
+	}
+
+	c := &collector{dir: dir, max: max, log: log, seen: make(map[string]bool)}
+
+	filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == src || d.IsDir() {
+			return nil
+		}
+		name, _ := filepath.Rel(src, path)
+		if src == dir {
+			c.keep(name, path, d)
+		} else if fi, err := d.Info(); err != nil {
+			c.skip(name, "%v", err)
+		} else {
+			c.copy(name, path, fi)
+		}
+		return nil
+	})
+	if src != dir {
+		os.RemoveAll(src)
+	}
+
+	workDir := task.Dir
+	if workDir == "" {
+		workDir = "."
+	}
+	for _, pattern := range task.Artifacts {
+		matches, _ := filepath.Glob(filepath.Join(workDir, pattern)) // validated with the config
+		for _, path := range matches {
+			fi, err := os.Lstat(path)
+			if err != nil || fi.IsDir() {
+				continue
+			}
+			name, _ := filepath.Rel(workDir, path)
+			c.copy(name, path, fi)
+		}
+	}
+
+	removeEmpty(dir)
+
+	sort.Slice(c.artifacts, func(i, j int) bool { return c.artifacts[i].Name < c.artifacts[j].Name })
+	log.Flush()
+	return c.artifacts
+}
+
+type collector struct {
+	dir       string
+	max       int64
+	total     int64
+	log       *stdstreams.Log
+	seen      map[string]bool
+	artifacts []model.Artifact
+}
+
+func (c *collector) skip(name, format string, a ...interface{}) {
+	fmt.Fprintf(c.log.Stderr(), "optask: artifact %v not kept: %v\n", filepath.ToSlash(name), fmt.Sprintf(format, a...))
+}
+
+// fits reports whether a file of the given size fits into the size limit.
+func (c *collector) fits(name string, size int64) bool {
+	if c.total+size > c.max {
+		c.skip(name, "size limit of %d bytes exceeded", c.max)
+		return false
+	}
+	return true
+}
+
+// keep records a file the process wrote to the artifacts directory, removing it if it is not
+// kept.
+func (c *collector) keep(name, path string, d fs.DirEntry) {
+	fi, err := d.Info()
+	if err == nil && !fi.Mode().IsRegular() {
+		err = errors.New("not a regular file")
+	}
+	if err == nil && !c.fits(name, fi.Size()) {
+		os.Remove(path)
+		return
+	}
+
+	var sum string
+	if err == nil {
+		sum, err = checksum(path)
+	}
+	if err != nil {
+		c.skip(name, "%v", err)
+		os.Remove(path)
+		return
+	}
+
+	c.add(name, fi.Size(), sum)
+}
+
+// copy copies a file matching an artifact pattern to the artifacts directory.
+func (c *collector) copy(name, path string, fi fs.FileInfo) {
+	if c.seen[filepath.ToSlash(name)] {
+		c.skip(name, "already kept from $OPTASK_ARTIFACTS")
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		c.skip(name, "not a regular file")
+		return
+	}
+	if !c.fits(name, fi.Size()) {
+		return
+	}
+
+	size, sum, err := copyFile(filepath.Join(c.dir, name), path, fi)
+	if err != nil {
+		c.skip(name, "%v", err)
+		return
+	}
+	c.add(name, size, sum)
+}
+
+func (c *collector) add(name string, size int64, sum string) {
+	name = filepath.ToSlash(name)
+	c.seen[name] = true
+	c.total += size
+	c.artifacts = append(c.artifacts, model.Artifact{Name: name, Size: size, SHA256: sum})
+}
+
+// copyFile copies src to dst and returns the number of bytes copied and their checksum. It
+// fails if src is no longer the file described by fi, like when it was replaced by a link.
+func copyFile(dst, src string, fi fs.FileInfo) (int64, string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+
+	if st, err := in.Stat(); err != nil {
+		return 0, "", err
+	} else if !os.SameFile(st, fi) {
+		return 0, "", errors.New("file changed while copying")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), DataDirPerm); err != nil {
+		return 0, "", err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, "", err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// removeEmpty removes empty directories in dir, including dir itself.
+func removeEmpty(dir string) {
+	var dirs []string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i]) // fails unless empty
+	}
+}
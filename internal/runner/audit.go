@@ -0,0 +1,21 @@
+package runner
+
+import (
+	"time"
+
+	"github.com/ngrash/optask/internal/db"
+	"github.com/ngrash/optask/internal/model"
+)
+
+// RecordAudit appends e to the audit log, setting its time if it is zero.
+func (s *Service) RecordAudit(e *model.AuditEntry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	return s.db.AppendAudit(e)
+}
+
+// AuditLog returns entries of the audit log. See db.Store.
+func (s *Service) AuditLog(f db.AuditFilter, count int) ([]*model.AuditEntry, error) {
+	return s.db.Audit(f, count)
+}
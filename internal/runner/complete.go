@@ -0,0 +1,36 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+// complete saves a completed run with its output. Errors saving the output are recorded on the
+// run. Errors saving the run itself are logged, leaving it incomplete in the database.
+func (s *Service) complete(task model.Task, r *model.Run, l *stdstreams.Log) {
+	if err := s.db.SaveLog(task.ID, r.ID, l); err != nil {
+		r.Outcome, r.Error = model.Error, fmt.Sprintf("saving output: %v", err)
+	}
+	if err := s.db.SaveRun(task.ID, r); err != nil {
+		log.Printf("saving run %v of %v: %v", r.ID, task.ID, err)
+	}
+
+	s.mu.Lock()
+	delete(s.runs[task.ID], r.ID)
+	s.mu.Unlock()
+
+	s.prune(task)
+}
+
+// failRun completes a run optask failed to start with the outcome model.Error.
+func (s *Service) failRun(task model.Task, r *model.Run, l *stdstreams.Log, err error) (model.RunID, error) {
+	r.Completed = time.Now()
+	r.ExitCode = -1
+	r.Outcome, r.Error = model.Error, err.Error()
+	s.complete(task, r, l)
+	return r.ID, nil
+}
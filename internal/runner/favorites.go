@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+// favorites are the pinned tasks of each user by user name. Without users, everyone shares
+// the favorites of the empty name.
+type favorites map[string][]model.TaskID
+
+func favoritesFile(p *model.Project, dataDir string) string {
+	return filepath.Join(dataDir, p.ID+".favorites.json")
+}
+
+func loadFavorites(path string) (favorites, error) {
+	favs := make(favorites)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return favs, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &favs); err != nil {
+		return nil, fmt.Errorf("reading favorites from %v: %w", path, err)
+	}
+	return favs, nil
+}
+
+// Favorites returns the tasks pinned by user.
+func (s *Service) Favorites(user string) []model.TaskID {
+	s.favoritesMu.Lock()
+	defer s.favoritesMu.Unlock()
+	return append([]model.TaskID(nil), s.favorites[user]...)
+}
+
+// SetFavorite pins a task for user or, if pinned is false, unpins it. Favorites are kept in
+// the data directory.
+func (s *Service) SetFavorite(user string, tID model.TaskID, pinned bool) error {
+	if _, err := s.Task(tID); err != nil {
+		return err
+	}
+
+	s.favoritesMu.Lock()
+	defer s.favoritesMu.Unlock()
+
+	var ids []model.TaskID
+	for _, id := range s.favorites[user] {
+		if id != tID {
+			ids = append(ids, id)
+		}
+	}
+	if pinned {
+		ids = append(ids, tID)
+	}
+
+	favs := make(favorites, len(s.favorites))
+	for u, f := range s.favorites {
+		favs[u] = f
+	}
+	if len(ids) > 0 {
+		favs[user] = ids
+	} else {
+		delete(favs, user)
+	}
+
+	b, err := json.Marshal(favs)
+	if err != nil {
+		return err
+	}
+	path := favoritesFile(s.project, s.dataDir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	s.favorites = favs
+	return nil
+}
@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+// BlockedError is returned when a run must not start, see Service.Blocked.
+type BlockedError struct {
+	TaskID model.TaskID
+	Reason string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("task %v is blocked: %v", e.TaskID, e.Reason)
+}
+
+// Blocked returns why runs of task must not start at t, or an empty string if they may. All
+// tasks are blocked during a freeze. Otherwise the windows of the task and its tags decide.
+func (s *Service) Blocked(task model.Task, t time.Time) string {
+	if f := s.Frozen(); f != nil {
+		return "frozen: " + f.Reason
+	}
+
+	return s.windows.TaskBlocked(task, t)
+}
+
+// allow returns a *BlockedError if task is blocked. If override is set, the run may start
+// anyway and the override is recorded on r.
+func (s *Service) allow(task model.Task, r *model.Run, user string, override bool) error {
+	reason := s.Blocked(task, time.Now())
+	if reason == "" {
+		return nil
+	}
+	if !override {
+		return &BlockedError{task.ID, reason}
+	}
+	r.Override, r.OverrideBy = reason, user
+	return nil
+}
+
+// freezeRecord is the stored representation of a model.Freeze.
+type freezeRecord struct {
+	Reason string    `json:"Reason"`
+	User   string    `json:"User"`
+	Since  time.Time `json:"Since"`
+}
+
+func freezeFile(p *model.Project, dataDir string) string {
+	return filepath.Join(dataDir, p.ID+".freeze.json")
+}
+
+func loadFreeze(path string) (*model.Freeze, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var rec freezeRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, fmt.Errorf("reading freeze from %v: %w", path, err)
+	}
+	return &model.Freeze{Reason: rec.Reason, User: rec.User, Since: rec.Since}, nil
+}
+
+// Frozen returns the current freeze, or nil if there is none.
+func (s *Service) Frozen() *model.Freeze {
+	s.freezeMu.Lock()
+	defer s.freezeMu.Unlock()
+	return s.freeze
+}
+
+// Freeze blocks all runs until Unfreeze is called, see Blocked. The freeze is kept in the data
+// directory and survives restarts.
+func (s *Service) Freeze(f model.Freeze) error {
+	if f.Since.IsZero() {
+		f.Since = time.Now()
+	}
+
+	b, err := json.Marshal(freezeRecord{f.Reason, f.User, f.Since})
+	if err != nil {
+		return err
+	}
+
+	s.freezeMu.Lock()
+	defer s.freezeMu.Unlock()
+
+	path := freezeFile(s.project, s.dataDir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	s.freeze = &f
+	return nil
+}
+
+// Unfreeze lifts the current freeze.
+func (s *Service) Unfreeze() error {
+	s.freezeMu.Lock()
+	defer s.freezeMu.Unlock()
+
+	err := os.Remove(freezeFile(s.project, s.dataDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.freeze = nil
+	return nil
+}
@@ -0,0 +1,44 @@
+package runner
+
+import (
+	"flag"
+	"strconv"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+// LimitCommand is the hidden optask command that applies resource limits before it replaces
+// itself with the process of a task, see ExecLimited. Unlike setting the limits after the
+// process started, this covers every child of the process.
+const LimitCommand = "limit"
+
+func hasRlimits(l model.ResourceLimits) bool {
+	return l.CPUSeconds > 0 || l.AddressSpace > 0 || l.OpenFiles > 0 || l.Processes > 0
+}
+
+func hasCgroupLimits(l model.ResourceLimits) bool {
+	return l.Memory > 0 || l.CPUQuota > 0
+}
+
+func limitFlags(l *model.ResourceLimits) *flag.FlagSet {
+	fs := flag.NewFlagSet(LimitCommand, flag.ContinueOnError)
+	fs.Uint64Var(&l.CPUSeconds, "cpu", 0, "")
+	fs.Uint64Var(&l.AddressSpace, "as", 0, "")
+	fs.Uint64Var(&l.OpenFiles, "nofile", 0, "")
+	fs.Uint64Var(&l.Processes, "nproc", 0, "")
+	return fs
+}
+
+// limitArgs returns the arguments of the limit command running name with args.
+func limitArgs(l model.ResourceLimits, name string, args []string) []string {
+	format := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	ret := []string{
+		LimitCommand,
+		"-cpu", format(l.CPUSeconds),
+		"-as", format(l.AddressSpace),
+		"-nofile", format(l.OpenFiles),
+		"-nproc", format(l.Processes),
+		"--", name,
+	}
+	return append(ret, args...)
+}
@@ -0,0 +1,138 @@
+//go:build linux
+
+package runner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+const rlimitNproc = 6 // RLIMIT_NPROC, missing in package syscall
+
+const cpuPeriod = 100000 // period of cpu.max in microseconds
+
+// ExecLimited implements the limit command: it applies the limits given by args and replaces
+// the current process with the command following them.
+func ExecLimited(args []string) error {
+	var l model.ResourceLimits
+	fs := limitFlags(&l)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("missing command")
+	}
+
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{syscall.RLIMIT_CPU, l.CPUSeconds},
+		{syscall.RLIMIT_AS, l.AddressSpace},
+		{syscall.RLIMIT_NOFILE, l.OpenFiles},
+		{rlimitNproc, l.Processes},
+	}
+	for _, lim := range limits {
+		if lim.value == 0 {
+			continue
+		}
+		if err := syscall.Setrlimit(lim.resource, &syscall.Rlimit{Cur: lim.value, Max: lim.value}); err != nil {
+			return fmt.Errorf("setting resource limit %d: %w", lim.resource, err)
+		}
+	}
+
+	path, err := exec.LookPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, fs.Args(), os.Environ())
+}
+
+// checkCgroupRoot checks that dir is a cgroup v2 directory and enables the memory and cpu
+// controllers for the cgroups of runs created in it.
+func checkCgroupRoot(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("%v is not a cgroup v2 directory: %w", dir, err)
+	}
+	return os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+memory +cpu"), 0)
+}
+
+// cgroup is the cgroup a single run is placed in.
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// newCgroup creates a cgroup named name in root, applying the Memory and CPUQuota limits.
+func newCgroup(root, name string, l model.ResourceLimits) (*cgroup, error) {
+	path := filepath.Join(root, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &cgroup{path: path}
+	if l.Memory > 0 {
+		if err := c.write("memory.max", fmt.Sprint(l.Memory)); err != nil {
+			c.remove()
+			return nil, err
+		}
+	}
+	if l.CPUQuota > 0 {
+		if err := c.write("cpu.max", fmt.Sprintf("%d %d", int(l.CPUQuota*cpuPeriod), cpuPeriod)); err != nil {
+			c.remove()
+			return nil, err
+		}
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		c.remove()
+		return nil, err
+	}
+	c.dir = dir
+	return c, nil
+}
+
+func (c *cgroup) write(file, value string) error {
+	return os.WriteFile(filepath.Join(c.path, file), []byte(value), 0)
+}
+
+// apply makes cmd start in the cgroup.
+func (c *cgroup) apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.dir.Fd())
+}
+
+// finish reports whether processes were killed for exceeding the memory limit, kills
+// remaining children and removes the cgroup.
+func (c *cgroup) finish(stderr io.Writer) {
+	if f, err := os.Open(filepath.Join(c.path, "memory.events")); err == nil {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if n, ok := strings.CutPrefix(s.Text(), "oom_kill "); ok && n != "0" {
+				fmt.Fprintf(stderr, "optask: %v process(es) killed for exceeding the memory limit\n", n)
+			}
+		}
+		f.Close()
+	}
+
+	c.write("cgroup.kill", "1") // children left running in the background
+	c.dir.Close()
+	c.remove()
+}
+
+func (c *cgroup) remove() {
+	os.Remove(c.path) // fails while killed children are still exiting
+}
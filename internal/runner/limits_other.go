@@ -0,0 +1,31 @@
+//go:build !linux
+
+package runner
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+var errNoLimits = errors.New("resource limits are only supported on Linux")
+
+func ExecLimited(args []string) error {
+	return errNoLimits
+}
+
+func checkCgroupRoot(dir string) error {
+	return errNoLimits
+}
+
+type cgroup struct{}
+
+func newCgroup(root, name string, l model.ResourceLimits) (*cgroup, error) {
+	return nil, errNoLimits
+}
+
+func (c *cgroup) apply(cmd *exec.Cmd) {}
+
+func (c *cgroup) finish(stderr io.Writer) {}
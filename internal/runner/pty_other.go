@@ -0,0 +1,23 @@
+//go:build !linux
+
+package runner
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var errNoPTY = errors.New("pseudo-terminals are only supported on Linux")
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errNoPTY
+}
+
+func setWinsize(f *os.File, rows, cols uint16) error {
+	return errNoPTY
+}
+
+func ttyAttr() *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,44 @@
+package runner
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/results"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+const maxOutputSize = 1 << 20 // bytes read from $OPTASK_OUTPUT
+
+// createOutputFile creates the file passed to the process as $OPTASK_OUTPUT.
+// The file stays open to read it back without following its path, which the process may have
+// replaced, e.g. with a symlink to a file it must not read.
+func createOutputFile() (*os.File, error) {
+	return os.CreateTemp("", "optask-output-")
+}
+
+// readResults collects the results reported in standard output and the output file, if any,
+// which is closed and removed. Results in the file take precedence.
+func readResults(file *os.File, log *stdstreams.Log) ([]model.Result, string) {
+	var p results.Parser
+	for _, line := range log.Lines() {
+		if line.Stream == stdstreams.Out {
+			p.LogLine(line.Text)
+		}
+	}
+
+	if file != nil {
+		if _, err := file.Seek(0, io.SeekStart); err == nil {
+			sc := bufio.NewScanner(io.LimitReader(file, maxOutputSize))
+			for sc.Scan() {
+				p.Line(sc.Text())
+			}
+		}
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	return p.Results, p.Summary
+}
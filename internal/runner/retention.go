@@ -0,0 +1,49 @@
+package runner
+
+import (
+	"log"
+	"os"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+const pruneBatch = 100 // number of runs read at once when deleting old runs
+
+// prune deletes the runs of a task older than the latest task.KeepRuns runs, together with
+// their logs and artifacts. Runs that are still running are kept.
+func (s *Service) prune(task model.Task) {
+	if task.KeepRuns == 0 {
+		return
+	}
+
+	latest, err := s.db.Runs(task.ID, "", task.KeepRuns)
+	if err != nil || len(latest) < task.KeepRuns {
+		return
+	}
+
+	before := latest[len(latest)-1].ID
+	for {
+		runs, err := s.db.Runs(task.ID, before, pruneBatch)
+		if err != nil {
+			log.Printf("deleting old runs of %v: %v", task.ID, err)
+			return
+		}
+		if len(runs) == 0 {
+			return
+		}
+
+		for _, r := range runs {
+			if s.IsRunning(task.ID, r.ID) {
+				continue
+			}
+			if err := s.db.DeleteRun(task.ID, r.ID); err != nil {
+				log.Printf("deleting run %v of %v: %v", r.ID, task.ID, err)
+				return
+			}
+			if err := os.RemoveAll(s.ArtifactsDir(task.ID, r.ID)); err != nil {
+				log.Printf("deleting artifacts of run %v of %v: %v", r.ID, task.ID, err)
+			}
+		}
+		before = runs[len(runs)-1].ID
+	}
+}
@@ -0,0 +1,30 @@
+package runner
+
+import (
+	"os"
+
+	"github.com/ngrash/optask/internal/credential"
+)
+
+// prepareRunAs checks that a process may run as the user of c and makes the output file
+// writable by it. Since the artifacts directory of the run is private to optask, it returns a
+// temporary directory owned by the user for the process to write artifacts to instead, see
+// collectArtifacts.
+func prepareRunAs(c *credential.Credential, output *os.File) (string, error) {
+	if err := checkRunAs(c); err != nil {
+		return "", err
+	}
+	if err := output.Chown(int(c.UID), int(c.GID)); err != nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp("", "optask-artifacts-")
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chown(dir, int(c.UID), int(c.GID)); err != nil {
+		os.Remove(dir)
+		return "", err
+	}
+	return dir, nil
+}
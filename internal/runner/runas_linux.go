@@ -0,0 +1,28 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/ngrash/optask/internal/credential"
+)
+
+// checkRunAs checks that processes may be started as the user of c.
+func checkRunAs(c *credential.Credential) error {
+	if uid := os.Geteuid(); uid != 0 && uint32(uid) != c.UID {
+		return fmt.Errorf("optask must run as root to run tasks as user %v", c.Name)
+	}
+	return nil
+}
+
+// applyRunAs makes cmd start as the user of c.
+func applyRunAs(cmd *exec.Cmd, c *credential.Credential) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: c.UID, Gid: c.GID, Groups: c.Groups}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package runner
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/ngrash/optask/internal/credential"
+)
+
+func checkRunAs(c *credential.Credential) error {
+	return errors.New("running tasks as another user is only supported on Linux")
+}
+
+func applyRunAs(cmd *exec.Cmd, c *credential.Credential) {}
@@ -1,21 +1,46 @@
 package runner
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"strings"
+	"time"
 
+	"github.com/ngrash/optask/internal/credential"
+	"github.com/ngrash/optask/internal/model"
 	"github.com/ngrash/optask/internal/stdstreams"
 )
 
+// ttyDrainTimeout limits how long output of a pseudo-terminal is read after the process
+// exited, since children of the process might keep the terminal open.
+const ttyDrainTimeout = 1 * time.Second
+
+// eot is written to a pseudo-terminal to signal the end of input.
+const eot = "\x04"
+
 type runner struct {
 	jobs chan *jobInfo
 }
 
-type doneFunc func(exit int)
+// doneFunc is called when a job completed. err is set if optask failed to run the process.
+type doneFunc func(exit int, err error)
 
 type jobInfo struct {
-	cmd    *exec.Cmd
-	log    *stdstreams.Log
-	doneFn doneFunc
+	cmd         *exec.Cmd
+	log         *stdstreams.Log
+	tty         *model.TTYSize         // run in a pseudo-terminal of this size if not nil
+	stdin       string                 // written to the standard input of the process
+	interactive bool                   // keep standard input open for Input
+	cgroup      *cgroup                // cgroup to start the process in, nil if none
+	runAs       *credential.Credential // user to start the process as, nil for the user of optask
+	doneFn      doneFunc
+
+	input   io.WriteCloser // standard input of the process, nil if not connected
+	child   *os.File       // end of the input pipe or terminal passed to the process
+	ttyDone chan struct{}  // closed when all output of the pseudo-terminal was read
 }
 
 func newRunner() *runner {
@@ -25,9 +50,14 @@ func newRunner() *runner {
 	return r
 }
 
-func (r *runner) Run(name string, args []string, log *stdstreams.Log, doneFn doneFunc) {
-	cmd := exec.Command(name, args...)
-	r.jobs <- &jobInfo{cmd, log, doneFn}
+// Run connects the standard streams of a job and starts it in the background.
+func (r *runner) Run(job *jobInfo) error {
+	if err := job.prepare(); err != nil {
+		return err
+	}
+
+	r.jobs <- job
+	return nil
 }
 
 func (r *runner) dispatchAndLoop() {
@@ -38,27 +68,144 @@ func (r *runner) dispatchAndLoop() {
 }
 
 func (r *runner) run(job *jobInfo) {
-	job.cmd.Stdout = job.log.Stdout()
-	job.cmd.Stderr = job.log.Stderr()
-
 	err := job.cmd.Start()
-	exitErr, isExitErr := err.(*exec.ExitError)
-	if err != nil && !isExitErr {
-		panic(err)
+	if job.child != nil {
+		job.child.Close() // the process has its own copy
+	}
+	if err == context.Canceled {
+		job.closeInput()
+		job.doneFn(-1, nil) // canceled before the process was started
+		return
+	}
+
+	if err != nil {
+		job.closeInput()
+		job.log.Flush()
+		job.doneFn(-1, fmt.Errorf("starting process: %w", err))
+		return
+	}
+
+	if job.input != nil {
+		go job.writeStdin()
 	}
 
 	err = job.cmd.Wait()
-	exitErr, isExitErr = err.(*exec.ExitError)
-	if err != nil && !isExitErr {
-		panic(err)
+	if _, ok := err.(*exec.ExitError); ok {
+		err = nil // the exit code is taken from the process state
+	} else if err != nil {
+		err = fmt.Errorf("waiting for process: %w", err)
+	}
+
+	if job.ttyDone != nil {
+		select {
+		case <-job.ttyDone:
+		case <-time.After(ttyDrainTimeout):
+		}
 	}
 
+	job.closeInput()
 	job.log.Flush()
 
-	exit := 0
-	if isExitErr {
-		exit = exitErr.ExitCode()
+	exit := -1
+	if ps := job.cmd.ProcessState; ps != nil {
+		exit = ps.ExitCode()
+	}
+
+	job.doneFn(exit, err)
+}
+
+// prepare connects the standard streams of the command to the log, either directly or
+// through a pseudo-terminal, places it in its cgroup and sets the user it runs as.
+func (job *jobInfo) prepare() error {
+	if job.tty != nil {
+		if err := job.prepareTTY(); err != nil {
+			return err
+		}
+	} else if err := job.preparePipes(); err != nil {
+		return err
+	}
+
+	if job.cgroup != nil {
+		job.cgroup.apply(job.cmd)
 	}
+	if job.runAs != nil {
+		applyRunAs(job.cmd, job.runAs)
+	}
+	return nil
+}
 
-	job.doneFn(exit)
+// preparePipes connects the standard streams of the command to the log.
+func (job *jobInfo) preparePipes() error {
+	job.cmd.Stdout = job.log.Stdout()
+	job.cmd.Stderr = job.log.Stderr()
+
+	if job.stdin != "" || job.interactive {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		job.cmd.Stdin = r
+		job.child, job.input = r, w
+	}
+
+	return nil
+}
+
+// prepareTTY connects the command to a new pseudo-terminal and copies its output to the log.
+func (job *jobInfo) prepareTTY() error {
+	master, slave, err := openPTY()
+	if err != nil {
+		return err
+	}
+
+	if err := setWinsize(master, job.tty.Rows, job.tty.Cols); err != nil {
+		master.Close()
+		slave.Close()
+		return err
+	}
+
+	job.cmd.Stdin = slave
+	job.cmd.Stdout = slave
+	job.cmd.Stderr = slave
+	job.cmd.SysProcAttr = ttyAttr()
+	job.child = slave
+	if job.stdin != "" || job.interactive {
+		job.input = master
+	}
+
+	job.ttyDone = make(chan struct{})
+	go func() {
+		defer close(job.ttyDone)
+		defer master.Close()
+		io.Copy(job.log.Terminal(job.tty.Rows, job.tty.Cols), master) // EIO once all slaves are closed
+	}()
+
+	return nil
+}
+
+// writeStdin writes the static input to the process and signals the end of input unless
+// the job is interactive.
+func (job *jobInfo) writeStdin() {
+	if job.stdin != "" {
+		for _, line := range strings.Split(strings.TrimSuffix(job.stdin, "\n"), "\n") {
+			job.log.Input(line)
+		}
+		io.WriteString(job.input, job.stdin)
+	}
+
+	if job.interactive {
+		return
+	}
+
+	if job.tty != nil {
+		io.WriteString(job.input, eot)
+	} else {
+		job.input.Close()
+	}
+}
+
+func (job *jobInfo) closeInput() {
+	if job.input != nil && job.tty == nil {
+		job.input.Close() // the master of a pseudo-terminal is closed after reading its output
+	}
 }
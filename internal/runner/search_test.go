@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/ngrash/optask/internal/api"
+	"github.com/ngrash/optask/internal/search"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+func TestSearchRunning(t *testing.T) {
+	s := newAgentService(t)
+	conn := s.ConnectAgent("db1", map[string]string{"role": "db"})
+	rID, err := s.Exec("db", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, conn)
+
+	ch := make(chan api.AgentReport)
+	reported := make(chan error)
+	go func() { reported <- s.ReportRun("db1", "db", rID, reports(ch, nil)) }()
+	ch <- api.AgentReport{Line: &stdstreams.Line{Stream: stdstreams.Out, Text: "connection refused"}}
+	ch <- api.AgentReport{Line: &stdstreams.Line{Stream: stdstreams.Out, Text: "retrying"}}
+
+	results, err := s.Search(search.Query{Text: "connect"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].RunID != rID || results[0].Text != "connection refused" {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+
+	ch <- api.AgentReport{Done: &api.AgentDone{}}
+	if err := <-reported; err != nil {
+		t.Fatal(err)
+	}
+	if results, _ := s.Search(search.Query{Text: "connect"}); len(results) != 1 {
+		t.Errorf("Expected the completed run to be found, got: %+v", results)
+	}
+}
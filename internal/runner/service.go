@@ -1,42 +1,96 @@
 package runner
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/ngrash/optask/internal/credential"
 	"github.com/ngrash/optask/internal/db"
 	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/outcome"
+	"github.com/ngrash/optask/internal/search"
 	"github.com/ngrash/optask/internal/stdstreams"
+	"github.com/ngrash/optask/internal/usage"
+	"github.com/ngrash/optask/internal/window"
 )
 
-const DataDir = "data"
 const DataDirPerm = 0700
 
+const cancelWaitDelay = 10 * time.Second // time between SIGTERM and SIGKILL when canceling a run
+
+// validParam matches parameter names that can be used in environment variable names.
+var validParam = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// paramRef matches references to parameters in the standard input of a task.
+var paramRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
 // Service is the domain context for running tasks.
 type Service struct {
-	project *model.Project
-	runner  *runner
-	db      *db.Adapter
-	runs    map[model.TaskID]map[model.RunID]runData
+	project  *model.Project
+	windows  *window.Project // compiled windows of project
+	runner   *runner
+	db       db.Store
+	storage  string
+	dataDir  string
+	cgroup   string     // directory the cgroups of runs are created in, see UseCgroup
+	mu       sync.Mutex // guards runs and requests
+	runs     map[model.TaskID]map[model.RunID]runData
+	requests map[string]*model.Request // runs waiting for approval by ID
+	freezeMu sync.Mutex                // guards freeze
+	freeze   *model.Freeze
+
+	favoritesMu sync.Mutex // guards favorites
+	favorites   favorites
+
+	agentsMu sync.Mutex            // guards agents and remote
+	agents   map[string]*agentConn // latest connection of each agent by name
+	remote   map[runKey]*remoteRun // runs assigned to agents
+
+	stats statsCache
 }
 
 type runData struct {
-	r *model.Run
-	l *stdstreams.Log
+	r      *model.Run
+	l      *stdstreams.Log
+	job    *jobInfo
+	cancel func() error
 }
 
-// NewService creates a new Service for a given project.
-// A database will be opened or created and a runner will be spawned in the background.
-func NewService(p *model.Project) *Service {
-	if err := os.MkdirAll(DataDir, DataDirPerm); err != nil {
+// NewService creates a new Service for a given project. A database of the given storage
+// backend (see db.Open) will be opened or created in dataDir and a runner will be spawned in
+// the background.
+func NewService(p *model.Project, dataDir, storage string) *Service {
+	if err := os.MkdirAll(dataDir, DataDirPerm); err != nil {
 		panic(err)
 	}
 
 	r := newRunner()
-	path := filepath.Join(DataDir, p.ID+".db")
-	db, err := db.NewAdapter(path, p)
+	db, err := db.Open(storage, DatabaseFile(p, dataDir, storage), p)
+	if err != nil {
+		panic(err)
+	}
+
+	windows, err := window.CompileProject(p)
+	if err != nil {
+		panic(err) // validated with the config
+	}
+
+	freeze, err := loadFreeze(freezeFile(p, dataDir))
+	if err != nil {
+		panic(err)
+	}
+
+	favs, err := loadFavorites(favoritesFile(p, dataDir))
 	if err != nil {
 		panic(err)
 	}
@@ -46,7 +100,35 @@ func NewService(p *model.Project) *Service {
 		runs[t.ID] = make(map[model.RunID]runData)
 	}
 
-	return &Service{p, r, db, runs}
+	return &Service{
+		project:   p,
+		windows:   windows,
+		runner:    r,
+		db:        db,
+		storage:   storage,
+		dataDir:   dataDir,
+		runs:      runs,
+		requests:  make(map[string]*model.Request),
+		freeze:    freeze,
+		favorites: favs,
+		agents:    make(map[string]*agentConn),
+		remote:    make(map[runKey]*remoteRun),
+	}
+}
+
+// UseCgroup places runs of tasks with Memory or CPUQuota limits in cgroups created in dir,
+// which must be a cgroup v2 directory writable by optask that has no processes of its own.
+func (s *Service) UseCgroup(dir string) error {
+	if err := checkCgroupRoot(dir); err != nil {
+		return err
+	}
+	s.cgroup = dir
+	return nil
+}
+
+// DatabaseFile returns the path of the database of project p in dataDir.
+func DatabaseFile(p *model.Project, dataDir, storage string) string {
+	return filepath.Join(dataDir, p.ID+db.Extension(storage))
 }
 
 // ListTasks lists all tasks defined in the project.
@@ -70,41 +152,229 @@ func (s *Service) Run(tID model.TaskID, rID model.RunID) (*model.Run, error) {
 	return s.db.Run(tID, rID)
 }
 
-// Exec starts the execution of a task returning the ID of the new run.
-func (s *Service) Exec(tID model.TaskID) (model.RunID, error) {
+// Exec starts the execution of a task returning the ID of the new run. Parameters are passed
+// to the process as environment variables named OPTASK_PARAM_<NAME>. The process may keep
+// files in $OPTASK_ARTIFACTS and report results to $OPTASK_OUTPUT, see package results.
+// Runs are refused with a *BlockedError while the task is blocked, see Blocked. Tasks
+// requiring approval cannot be started directly, see RequestExec.
+func (s *Service) Exec(tID model.TaskID, params map[string]string) (model.RunID, error) {
+	return s.exec(tID, params, "", false)
+}
+
+// ExecOverride starts a run like Exec, even if the task is blocked. The override is recorded
+// on the run together with the name of the user.
+func (s *Service) ExecOverride(tID model.TaskID, params map[string]string, user string) (model.RunID, error) {
+	return s.exec(tID, params, user, true)
+}
+
+func (s *Service) exec(tID model.TaskID, params map[string]string, user string, override bool) (model.RunID, error) {
 	task, err := s.Task(tID)
 	if err != nil {
 		return "", err
 	}
+	if task.Approval {
+		return "", fmt.Errorf("task %v requires approval", tID)
+	}
+
+	r := model.Run{Params: params}
+	if err := s.allow(task, &r, user, override); err != nil {
+		return "", err
+	}
+	return s.start(task, r)
+}
+
+func checkParams(params map[string]string) error {
+	for k := range params {
+		if !validParam.MatchString(k) {
+			return fmt.Errorf("invalid parameter name %q", k)
+		}
+	}
+	return nil
+}
+
+// expandParams replaces ${name} in s by the value of the parameter name, or by the empty string
+// if the parameter is not set. Other uses of $ are kept as they are.
+func expandParams(s string, params map[string]string) string {
+	return paramRef.ReplaceAllStringFunc(s, func(ref string) string {
+		return params[ref[2:len(ref)-1]]
+	})
+}
+
+// start starts a run of task. The run r is completed with the details of the process.
+func (s *Service) start(task model.Task, r model.Run) (model.RunID, error) {
+	tID, params := task.ID, r.Params
+	rules, err := outcome.Compile(task.Success)
+	if err != nil {
+		return "", err
+	}
+	if err := checkParams(params); err != nil {
+		return "", err
+	}
+
+	var paramEnv []string
+	for k, v := range params {
+		paramEnv = append(paramEnv, "OPTASK_PARAM_"+strings.ToUpper(k)+"="+v)
+	}
+	env := append(os.Environ(), paramEnv...)
+
+	name, args := task.Cmd, task.Args
+	if hasRlimits(task.Limits) {
+		self, err := os.Executable()
+		if err != nil {
+			return "", err
+		}
+		name, args = self, limitArgs(task.Limits, task.Cmd, task.Args)
+	}
 
 	log := stdstreams.NewLog()
 
-	r := model.Run{Started: time.Now()}
+	r.Started = time.Now()
 	if err := s.db.CreateRun(tID, &r); err != nil {
 		return "", err
 	}
 
-	s.runs[tID][r.ID] = runData{&r, log}
+	// From here on, errors are recorded on the run.
+	if len(task.Target) > 0 {
+		return s.startRemote(task, &r, rules, paramEnv, log)
+	}
 
-	s.runner.Run(task.Cmd, task.Args, log, func(exit int) {
-		r.Completed = time.Now()
-		r.ExitCode = exit
+	artifacts := s.ArtifactsDir(tID, r.ID)
+	if err := os.MkdirAll(artifacts, DataDirPerm); err != nil {
+		return s.failRun(task, &r, log, fmt.Errorf("creating artifacts directory: %w", err))
+	}
 
-		if err := s.db.SaveRun(tID, &r); err != nil {
-			panic(err)
+	output, err := createOutputFile()
+	if err != nil {
+		os.Remove(artifacts)
+		return s.failRun(task, &r, log, fmt.Errorf("creating output file: %w", err))
+	}
+	env = append(env, "OPTASK_OUTPUT="+output.Name())
+
+	src := artifacts // directory the process writes artifacts to
+	runAs, err := credential.Lookup(task.RunAs)
+	if err == nil && runAs != nil {
+		src, err = prepareRunAs(runAs, output)
+	}
+	if err != nil {
+		os.Remove(artifacts)
+		output.Close()
+		os.Remove(output.Name())
+		return s.failRun(task, &r, log, fmt.Errorf("running as user %v: %w", task.RunAs.User, err))
+	}
+	if runAs != nil {
+		env = append(env, "HOME="+runAs.Home, "USER="+runAs.Name, "LOGNAME="+runAs.Name)
+	}
+	env = append(env, "OPTASK_ARTIFACTS="+src)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = env
+	cmd.Dir = task.Dir
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = cancelWaitDelay
+
+	job := &jobInfo{cmd: cmd, log: log, interactive: task.Interactive, runAs: runAs}
+	job.stdin = expandParams(task.Stdin, params)
+	if task.TTY {
+		size := task.TTYSize
+		if size == (model.TTYSize{}) {
+			size = model.DefaultTTYSize
 		}
+		job.tty = &size
+	}
 
-		if err := s.db.SaveLog(tID, r.ID, log); err != nil {
-			panic(err)
+	if hasCgroupLimits(task.Limits) {
+		if s.cgroup == "" {
+			fmt.Fprintln(log.Stderr(), "optask: Memory and CPUQuota limits not applied: no cgroup configured")
+		} else if job.cgroup, err = newCgroup(s.cgroup, fmt.Sprintf("%v-%v-%v", s.project.ID, tID, r.ID), task.Limits); err != nil {
+			fmt.Fprintf(log.Stderr(), "optask: Memory and CPUQuota limits not applied: %v\n", err)
 		}
+		log.Flush()
+	}
 
-		delete(s.runs[tID], r.ID)
-	})
+	job.doneFn = func(exit int, err error) {
+		r.Completed = time.Now()
+		r.ExitCode = exit
+		r.Canceled = ctx.Err() != nil
+		if ps := cmd.ProcessState; ps != nil {
+			r.MaxRSS = usage.MaxRSS(ps)
+			r.CPUTime = usage.CPUTime(ps)
+		}
+		if job.cgroup != nil {
+			job.cgroup.finish(log.Stderr())
+			log.Flush()
+		}
+		r.Outcome = rules.Decide(exit, r.Canceled, log.Lines())
+		if err != nil {
+			r.Outcome, r.Error = model.Error, err.Error()
+		}
+		cancel()
+
+		r.Artifacts = collectArtifacts(task, artifacts, src, log)
+		r.Results, r.Summary = readResults(output, log)
+
+		s.complete(task, &r, log)
+	}
+
+	s.mu.Lock()
+	s.runs[tID][r.ID] = runData{&r, log, job, func() error { cancel(); return nil }}
+	s.mu.Unlock()
+
+	if err := s.runner.Run(job); err != nil {
+		job.doneFn(-1, fmt.Errorf("connecting standard streams: %w", err))
+	}
 
 	return r.ID, nil
 }
 
-// Runs returns runs of a given task. See db.Adapter.Runs.
+// Cancel stops a running run. The process receives SIGTERM and is killed if it does not exit
+// in time.
+func (s *Service) Cancel(tID model.TaskID, rID model.RunID) error {
+	s.mu.Lock()
+	run, ok := s.runs[tID][rID]
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("run %v of task %v is not running", rID, tID)
+	}
+
+	return run.cancel()
+}
+
+// Input writes a line to the standard input of an interactive run.
+func (s *Service) Input(tID model.TaskID, rID model.RunID, text string) error {
+	s.mu.Lock()
+	run, ok := s.runs[tID][rID]
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("run %v of task %v is not running", rID, tID)
+	}
+	if run.job == nil || !run.job.interactive {
+		return fmt.Errorf("task %v is not interactive", tID)
+	}
+
+	run.l.Input(text)
+	_, err := io.WriteString(run.job.input, text+"\n")
+	return err
+}
+
+// Backup writes a consistent copy of the database to w. See db.Store.
+func (s *Service) Backup(w io.Writer) (int64, error) {
+	return s.db.Backup(w)
+}
+
+// DatabaseExtension returns the file name extension of copies written by Backup.
+func (s *Service) DatabaseExtension() string {
+	return db.Extension(s.storage)
+}
+
+// Export writes all runs and logs to w in a portable format. See db.Store.
+func (s *Service) Export(w io.Writer) error {
+	return s.db.Export(w)
+}
+
+// Runs returns runs of a given task. See db.Store.
 func (s *Service) Runs(tID model.TaskID, before model.RunID, count int) ([]*model.Run, error) {
 	return s.db.Runs(tID, before, count)
 }
@@ -112,6 +382,8 @@ func (s *Service) Runs(tID model.TaskID, before model.RunID, count int) ([]*mode
 // IsRunning indicates whether a given run is currently being executed. A running run might
 // produce more output. Use StdStreams to access the output of any run.
 func (s *Service) IsRunning(tID model.TaskID, rID model.RunID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	_, ok := s.runs[tID][rID]
 	return ok
 }
@@ -123,9 +395,54 @@ func (s *Service) LatestRuns() (map[model.TaskID]*model.Run, error) {
 
 // StdStreams provides access to the output of a run, running or persisted.
 func (s *Service) StdStreams(tID model.TaskID, rID model.RunID) (*stdstreams.Log, error) {
+	s.mu.Lock()
 	run, ok := s.runs[tID][rID]
+	s.mu.Unlock()
 	if ok {
 		return run.l, nil
 	}
 	return s.db.Log(tID, rID)
 }
+
+// Search searches the output of runs. See search.Search.
+func (s *Service) Search(q search.Query) ([]search.Result, error) {
+	tasks := make([]model.TaskID, len(s.project.Tasks))
+	for i, t := range s.project.Tasks {
+		tasks[i] = t.ID
+	}
+	return search.Search(searchStore{s}, tasks, q)
+}
+
+// searchStore implements search.Store, including the output of running runs.
+type searchStore struct {
+	*Service
+}
+
+// Lookup looks up tokens in the index and in the output of running runs, which is indexed
+// when they complete.
+func (s searchStore) Lookup(tokens []string) ([]search.Ref, error) {
+	refs, err := s.db.Lookup(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[search.Ref]bool)
+	for _, ref := range refs {
+		seen[ref] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tID, runs := range s.runs {
+		for rID, run := range runs {
+			ref := search.Ref{TaskID: tID, RunID: rID}
+			if !seen[ref] && search.HasTokens(run.l, tokens) {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs, nil
+}
+
+func (s searchStore) Log(tID model.TaskID, rID model.RunID) (*stdstreams.Log, error) {
+	return s.StdStreams(tID, rID)
+}
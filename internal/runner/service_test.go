@@ -0,0 +1,36 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+func TestExpandParams(t *testing.T) {
+	params := map[string]string{"name": "world", "n_2": "2"}
+	tests := []struct {
+		in, out string
+	}{
+		{"hello ${name}", "hello world"},
+		{"${n_2}${name}", "2world"},
+		{"${missing}.", "."},
+		{"$name $1 $ $$ ${} ${1x} ${name", "$name $1 $ $$ ${} ${1x} ${name"},
+		{"price: 5$", "price: 5$"},
+	}
+	for _, test := range tests {
+		if out := expandParams(test.in, params); out != test.out {
+			t.Errorf("Expected %q for %q, got: %q", test.out, test.in, out)
+		}
+	}
+}
+
+func TestStartBadDir(t *testing.T) {
+	s := newTaskService(t, model.Task{ID: "t", Name: "T", Cmd: "true", Dir: "/no/such/dir"})
+	rID, err := s.Exec("t", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if r := waitCompleted(t, s, "t", rID); r.Outcome != model.Error || r.Error == "" {
+		t.Errorf("Expected a run with outcome %v and an error, got: %+v", model.Error, r)
+	}
+}
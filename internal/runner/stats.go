@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stats"
+)
+
+const (
+	statsRunLimit = 1000        // number of latest runs statistics are computed from
+	statsMaxAge   = time.Minute // time after which cached statistics are recomputed anyway
+)
+
+// statsCache holds the statistics of each task until a run is created or completes.
+type statsCache struct {
+	mu      sync.Mutex
+	entries map[model.TaskID]statsEntry
+}
+
+type statsEntry struct {
+	latest   model.Run // latest run when the statistics were computed
+	computed time.Time
+	stats    *stats.Stats
+}
+
+// Stats returns statistics about the latest runs of a task.
+func (s *Service) Stats(tID model.TaskID) (*stats.Stats, error) {
+	if _, err := s.Task(tID); err != nil {
+		return nil, err
+	}
+
+	latest, err := s.db.Runs(tID, "", 1)
+	if err != nil {
+		return nil, err
+	}
+	var key model.Run
+	if len(latest) > 0 {
+		key = *latest[0]
+	}
+
+	c := &s.stats
+	c.mu.Lock()
+	e, ok := c.entries[tID]
+	c.mu.Unlock()
+	if ok && e.latest.ID == key.ID && e.latest.Completed.Equal(key.Completed) && time.Since(e.computed) < statsMaxAge {
+		return e.stats, nil
+	}
+
+	runs, err := s.db.Runs(tID, "", statsRunLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	e = statsEntry{key, now, stats.Compute(runs, now)}
+
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[model.TaskID]statsEntry)
+	}
+	c.entries[tID] = e
+	c.mu.Unlock()
+
+	return e.stats, nil
+}
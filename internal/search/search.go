@@ -0,0 +1,282 @@
+// Package search implements full-text search across the output of runs.
+package search
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+const (
+	minTokenLen  = 2   // shorter words are not indexed
+	maxTokenLen  = 64  // longer words are truncated
+	pageSize     = 100 // number of runs read at once when scanning without index
+	DefaultLimit = 100 // maximum number of results if Query.Limit is not set
+)
+
+// Tokens splits text into the lower-cased words used as index keys. Each word is returned once.
+func Tokens(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isWordRune(r)
+	})
+
+	seen := make(map[string]bool)
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		if len(w) < minTokenLen {
+			continue
+		}
+		if len(w) > maxTokenLen {
+			w = w[:maxTokenLen]
+		}
+		if !seen[w] {
+			seen[w] = true
+			tokens = append(tokens, w)
+		}
+	}
+	return tokens
+}
+
+// Ref references a run of a task.
+type Ref struct {
+	TaskID model.TaskID
+	RunID  model.RunID
+}
+
+// Store provides the data needed for searching.
+type Store interface {
+	// Lookup returns the runs whose output contains words starting with each of the given
+	// tokens, see HasTokens.
+	Lookup(tokens []string) ([]Ref, error)
+	Runs(tID model.TaskID, before model.RunID, count int) ([]*model.Run, error)
+	Run(tID model.TaskID, rID model.RunID) (*model.Run, error)
+	Log(tID model.TaskID, rID model.RunID) (*stdstreams.Log, error)
+}
+
+// Query describes what to search for.
+type Query struct {
+	Text   string         // text a line must contain at the start of a word, ignoring case
+	Regex  bool           // interpret Text as a regular expression instead
+	Tasks  []model.TaskID // tasks to search, all if empty
+	From   time.Time      // only lines written at or after From, if not zero
+	To     time.Time      // only lines written before To, if not zero
+	Stream int            // only lines of this stream (stdstreams.Out or stdstreams.Err), if not zero
+	Limit  int            // maximum number of results, DefaultLimit if zero
+}
+
+// Result is a matching line.
+type Result struct {
+	TaskID model.TaskID
+	RunID  model.RunID
+	Index  int // index of the line in the log of the run
+	stdstreams.Line
+}
+
+// MarshalJSON encodes the result including the fields of its line. Without it, the
+// MarshalJSON method of the embedded line would encode the line only.
+func (r Result) MarshalJSON() ([]byte, error) {
+	type line stdstreams.Line // without the MarshalJSON method
+	return json.Marshal(struct {
+		TaskID model.TaskID
+		RunID  model.RunID
+		Index  int
+		line
+		HTML string
+	}{r.TaskID, r.RunID, r.Index, line(r.Line), r.Line.HTML()})
+}
+
+// Search returns lines matching q, latest runs first. tasks lists all tasks of the project.
+func Search(s Store, tasks []model.TaskID, q Query) ([]Result, error) {
+	match, err := q.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Limit == 0 {
+		q.Limit = DefaultLimit
+	}
+	if len(q.Tasks) > 0 {
+		tasks = q.Tasks
+	}
+
+	var refs []Ref
+	if tokens := Tokens(q.Text); !q.Regex && len(tokens) > 0 {
+		refs, err = lookup(s, tokens, tasks)
+	} else {
+		refs, err = scan(s, tasks, q.From)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err = inRange(s, refs, q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for _, ref := range refs {
+		log, err := s.Log(ref.TaskID, ref.RunID)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, l := range log.Lines() {
+			if q.Stream != 0 && l.Stream != q.Stream {
+				continue
+			}
+			if !q.From.IsZero() && l.Time.Before(q.From) {
+				continue
+			}
+			if !q.To.IsZero() && !l.Time.Before(q.To) {
+				continue
+			}
+			if !match(l.Text) {
+				continue
+			}
+
+			results = append(results, Result{ref.TaskID, ref.RunID, i, l})
+			if len(results) == q.Limit {
+				return results, nil
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func (q Query) matcher() (func(string) bool, error) {
+	if q.Regex {
+		re, err := regexp.Compile(q.Text)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+
+	text := strings.ToLower(q.Text)
+	return func(s string) bool {
+		return containsAtWordStart(strings.ToLower(s), text)
+	}, nil
+}
+
+// containsAtWordStart reports whether s contains sub starting at the start of a word, so
+// "connect" is found in "connection refused", but not "nnect". Like the index, see Tokens.
+func containsAtWordStart(s, sub string) bool {
+	first, _ := utf8.DecodeRuneInString(sub)
+	for i := 0; i <= len(s); {
+		j := strings.Index(s[i:], sub)
+		if j < 0 {
+			return false
+		}
+		j += i
+
+		before, _ := utf8.DecodeLastRuneInString(s[:j])
+		if j == 0 || !isWordRune(first) || !isWordRune(before) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(s[j:])
+		i = j + size
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// HasTokens reports whether the lines of l contain words starting with each of tokens.
+func HasTokens(l *stdstreams.Log, tokens []string) bool {
+	found := make(map[string]bool)
+	for _, line := range l.Lines() {
+		for _, word := range Tokens(line.Text) {
+			for _, t := range tokens {
+				if strings.HasPrefix(word, t) {
+					found[t] = true
+				}
+			}
+		}
+	}
+	return len(found) == len(tokens)
+}
+
+// lookup returns the indexed runs of tasks containing all tokens.
+func lookup(s Store, tokens []string, tasks []model.TaskID) ([]Ref, error) {
+	refs, err := s.Lookup(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[model.TaskID]bool)
+	for _, t := range tasks {
+		wanted[t] = true
+	}
+
+	ret := refs[:0]
+	for _, r := range refs {
+		if wanted[r.TaskID] {
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+// inRange returns the runs that might have written lines between from and to, latest first.
+func inRange(s Store, refs []Ref, from, to time.Time) ([]Ref, error) {
+	started := make(map[Ref]time.Time)
+	ret := refs[:0]
+	for _, ref := range refs {
+		run, err := s.Run(ref.TaskID, ref.RunID)
+		if err != nil {
+			return nil, err
+		}
+		if !to.IsZero() && !run.Started.Before(to) {
+			continue
+		}
+		if !from.IsZero() && !run.Completed.IsZero() && run.Completed.Before(from) {
+			continue
+		}
+		started[ref] = run.Started
+		ret = append(ret, ref)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return started[ret[i]].After(started[ret[j]])
+	})
+	return ret, nil
+}
+
+// scan returns all runs of tasks that completed after from.
+func scan(s Store, tasks []model.TaskID, from time.Time) ([]Ref, error) {
+	var refs []Ref
+	for _, tID := range tasks {
+		before := model.RunID("")
+		for {
+			runs, err := s.Runs(tID, before, pageSize)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, r := range runs {
+				if !from.IsZero() && !r.Completed.IsZero() && r.Completed.Before(from) {
+					runs = nil // older runs completed even earlier
+					break
+				}
+				refs = append(refs, Ref{tID, r.ID})
+			}
+
+			if len(runs) < pageSize {
+				break
+			}
+			before = runs[len(runs)-1].ID
+		}
+	}
+	return refs, nil
+}
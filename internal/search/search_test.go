@@ -0,0 +1,131 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+// memStore is a search.Store holding runs of a single task in memory.
+type memStore struct {
+	runs []*model.Run
+	logs []*stdstreams.Log
+}
+
+func (m *memStore) add(started time.Time, out, err string) {
+	l := stdstreams.NewLog()
+	l.Stdout().Write([]byte(out))
+	l.Stderr().Write([]byte(err))
+	m.logs = append(m.logs, l)
+
+	id := model.RunID(fmt.Sprint(len(m.runs) + 1))
+	m.runs = append(m.runs, &model.Run{ID: id, Started: started, Completed: started})
+}
+
+func (m *memStore) index(rID model.RunID) int {
+	var i int
+	fmt.Sscan(string(rID), &i)
+	return i - 1
+}
+
+func (m *memStore) Lookup(tokens []string) ([]Ref, error) {
+	var refs []Ref
+	for i, l := range m.logs {
+		if HasTokens(l, tokens) {
+			refs = append(refs, Ref{"t", m.runs[i].ID})
+		}
+	}
+	return refs, nil
+}
+
+func (m *memStore) Runs(tID model.TaskID, before model.RunID, count int) ([]*model.Run, error) {
+	end := len(m.runs)
+	if before != "" {
+		end = m.index(before)
+	}
+
+	var ret []*model.Run
+	for i := end - 1; i >= 0 && len(ret) < count; i-- {
+		ret = append(ret, m.runs[i])
+	}
+	return ret, nil
+}
+
+func (m *memStore) Run(tID model.TaskID, rID model.RunID) (*model.Run, error) {
+	return m.runs[m.index(rID)], nil
+}
+
+func (m *memStore) Log(tID model.TaskID, rID model.RunID) (*stdstreams.Log, error) {
+	return m.logs[m.index(rID)], nil
+}
+
+func TestTokens(t *testing.T) {
+	tokens := Tokens("dial tcp 10.0.0.1:5432: Connection refused, connection refused")
+	expected := []string{"dial", "tcp", "10", "5432", "connection", "refused"}
+
+	if fmt.Sprint(tokens) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got: %v", expected, tokens)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &memStore{}
+	s.add(day, "connecting\n", "connection refused\n")
+	s.add(day.AddDate(0, 0, 1), "connection refused\n", "")
+	s.add(day.AddDate(0, 0, 2), "all good\n", "")
+
+	tests := []struct {
+		name     string
+		q        Query
+		expected []model.RunID
+	}{
+		{"Text", Query{Text: "Connection Refused"}, []model.RunID{"2", "1"}},
+		{"Stream", Query{Text: "connection refused", Stream: stdstreams.Err}, []model.RunID{"1"}},
+		{"Regex", Query{Text: "^conn.*ing$", Regex: true}, []model.RunID{"1"}},
+		{"Limit", Query{Text: "refused", Limit: 1}, []model.RunID{"2"}},
+		{"Tasks", Query{Text: "refused", Tasks: []model.TaskID{"other"}}, nil},
+		{"NoMatch", Query{Text: "timeout"}, nil},
+		{"PartialWord", Query{Text: "connect"}, []model.RunID{"2", "1", "1"}},
+		{"PartialWords", Query{Text: "connection ref"}, []model.RunID{"2", "1"}},
+		{"InsideWord", Query{Text: "nnect"}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := Search(s, []model.TaskID{"t"}, test.q)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var runs []model.RunID
+			for _, r := range results {
+				runs = append(runs, r.RunID)
+			}
+
+			if fmt.Sprint(runs) != fmt.Sprint(test.expected) {
+				t.Errorf("Expected runs %v, got: %v", test.expected, runs)
+			}
+		})
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	r := Result{"t", "1", 2, stdstreams.Line{Stream: stdstreams.Out, Text: "hello"}}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var v map[string]interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatalf("Invalid JSON %s: %v", b, err)
+	}
+	if v["TaskID"] != "t" || v["RunID"] != "1" || v["Text"] != "hello" || v["HTML"] != "hello" {
+		t.Errorf("Unexpected JSON: %s", b)
+	}
+}
@@ -0,0 +1,161 @@
+// Package stats computes statistics about the runs of a task.
+package stats
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+// Windows are the time windows for which success rates are computed.
+var Windows = []Window{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+	{"all", 0},
+}
+
+// A Window is a period of time ending now. A Duration of zero covers all runs.
+type Window struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Rate is the success rate of the runs in a Window. Runs with a warning count as succeeded.
+type Rate struct {
+	Window    string
+	Runs      int
+	Succeeded int
+	Warnings  int
+	Errors    int     // runs optask failed to start or complete, see model.Error
+	Rate      float64 // Succeeded / Runs, 0 if there are no runs
+}
+
+// Point is a completed run in the duration trend.
+type Point struct {
+	RunID    model.RunID
+	Started  time.Time
+	Duration time.Duration
+	Outcome  model.Outcome
+}
+
+// ExitCode counts the runs that exited with Code.
+type ExitCode struct {
+	Code  int
+	Count int
+}
+
+// Stats summarizes the completed runs of a task. Canceled runs only count towards Runs. Runs
+// succeed according to their Outcome, see model.SuccessRules.
+type Stats struct {
+	Runs        int // completed runs considered, including canceled ones
+	Rates       []Rate
+	Mean        time.Duration
+	P50         time.Duration
+	P95         time.Duration
+	Trend       []Point    // oldest first
+	ExitCodes   []ExitCode // most common first
+	LastSuccess time.Time  // zero if no run succeeded
+	LastFailure time.Time  // zero if no run failed
+	// Flakiness is the share of consecutive runs whose outcome differs from the run before,
+	// from 0 for tasks that always or never succeed to 1 for tasks alternating between
+	// success and failure.
+	Flakiness float64
+}
+
+// Compute computes Stats of runs, which are ordered latest first as returned by
+// db.Store.Runs. Runs that did not complete yet are ignored.
+func Compute(runs []*model.Run, now time.Time) *Stats {
+	s := &Stats{}
+
+	var done []*model.Run // completed and not canceled, oldest first
+	for i := len(runs) - 1; i >= 0; i-- {
+		r := runs[i]
+		if r.Completed.IsZero() {
+			continue
+		}
+		s.Runs++
+		if !r.Canceled {
+			done = append(done, r)
+		}
+	}
+
+	for _, w := range Windows {
+		rate := Rate{Window: w.Name}
+		for _, r := range done {
+			if w.Duration != 0 && now.Sub(r.Started) > w.Duration {
+				continue
+			}
+			rate.Runs++
+			if r.Outcome.OK() {
+				rate.Succeeded++
+			}
+			if r.Outcome == model.Warning {
+				rate.Warnings++
+			}
+			if r.Outcome == model.Error {
+				rate.Errors++
+			}
+		}
+		if rate.Runs > 0 {
+			rate.Rate = float64(rate.Succeeded) / float64(rate.Runs)
+		}
+		s.Rates = append(s.Rates, rate)
+	}
+
+	if len(done) == 0 {
+		return s
+	}
+
+	codes := make(map[int]int)
+	durations := make([]time.Duration, len(done))
+	var total time.Duration
+	changes := 0
+	for i, r := range done {
+		d := r.Completed.Sub(r.Started)
+		durations[i] = d
+		total += d
+
+		succeeded := r.Outcome.OK()
+		s.Trend = append(s.Trend, Point{r.ID, r.Started, d, r.Outcome})
+		codes[r.ExitCode]++
+
+		if succeeded {
+			s.LastSuccess = r.Completed
+		} else {
+			s.LastFailure = r.Completed
+		}
+		if i > 0 && succeeded != done[i-1].Outcome.OK() {
+			changes++
+		}
+	}
+
+	s.Mean = total / time.Duration(len(done))
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	s.P50 = percentile(durations, 50)
+	s.P95 = percentile(durations, 95)
+
+	if len(done) > 1 {
+		s.Flakiness = float64(changes) / float64(len(done)-1)
+	}
+
+	for code, n := range codes {
+		s.ExitCodes = append(s.ExitCodes, ExitCode{code, n})
+	}
+	sort.Slice(s.ExitCodes, func(i, j int) bool {
+		a, b := s.ExitCodes[i], s.ExitCodes[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Code < b.Code
+	})
+
+	return s
+}
+
+// percentile returns the p-th percentile of sorted using the nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100 // ceil(p/100 * n)
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
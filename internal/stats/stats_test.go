@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+func TestCompute(t *testing.T) {
+	now := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	// latest first, like db.Store.Runs
+	var runs []*model.Run
+	add := func(age time.Duration, d time.Duration, exit int, canceled bool) {
+		started := now.Add(-age)
+		id := model.RunID(fmt.Sprint(10 - len(runs)))
+		o := model.DefaultOutcome(exit, canceled)
+		if exit == 3 {
+			o = model.Warning
+		}
+		runs = append(runs, &model.Run{ID: id, Started: started, Completed: started.Add(d), ExitCode: exit, Canceled: canceled, Outcome: o})
+	}
+	runs = append(runs, &model.Run{ID: "11", Started: now}) // running
+	add(time.Hour, 10*time.Second, 0, false)
+	add(2*time.Hour, 20*time.Second, 1, false)
+	add(3*time.Hour, 30*time.Second, 0, true)
+	add(48*time.Hour, 40*time.Second, 3, false)
+	add(20*24*time.Hour, 50*time.Second, 1, false)
+
+	s := Compute(runs, now)
+
+	if s.Runs != 5 {
+		t.Errorf("Expected 5 runs, got: %v", s.Runs)
+	}
+
+	rates := fmt.Sprint(s.Rates)
+	expected := "[{24h 2 1 0 0 0.5} {7d 3 2 1 0 0.6666666666666666} {30d 4 2 1 0 0.5} {all 4 2 1 0 0.5}]"
+	if rates != expected {
+		t.Errorf("Expected rates %v, got: %v", expected, rates)
+	}
+
+	if s.Mean != 30*time.Second || s.P50 != 20*time.Second || s.P95 != 50*time.Second {
+		t.Errorf("Expected mean 30s, p50 20s, p95 50s, got: %v, %v, %v", s.Mean, s.P50, s.P95)
+	}
+
+	if len(s.Trend) != 4 || s.Trend[0].Duration != 50*time.Second {
+		t.Errorf("Expected trend of 4 runs oldest first, got: %v", s.Trend)
+	}
+
+	if fmt.Sprint(s.ExitCodes) != "[{1 2} {0 1} {3 1}]" {
+		t.Errorf("Unexpected exit codes: %v", s.ExitCodes)
+	}
+
+	if !s.LastSuccess.Equal(now.Add(-time.Hour + 10*time.Second)) {
+		t.Errorf("Unexpected last success: %v", s.LastSuccess)
+	}
+
+	// outcomes oldest first: fail, warning, fail, success
+	if s.Flakiness != 1 {
+		t.Errorf("Expected flakiness 1, got: %v", s.Flakiness)
+	}
+}
+
+func TestComputeErrors(t *testing.T) {
+	now := time.Now()
+	runs := []*model.Run{
+		{ID: "2", Started: now, Completed: now, ExitCode: -1, Outcome: model.Error, Error: "starting process: no such file"},
+		{ID: "1", Started: now, Completed: now, Outcome: model.Succeeded},
+	}
+
+	s := Compute(runs, now)
+	all := s.Rates[len(s.Rates)-1]
+	if all.Runs != 2 || all.Succeeded != 1 || all.Errors != 1 {
+		t.Errorf("Expected 1 of 2 runs succeeded and 1 error, got: %+v", all)
+	}
+	if !s.LastFailure.Equal(now) {
+		t.Errorf("Expected the error to count as failure, got last failure: %v", s.LastFailure)
+	}
+}
+
+func TestComputeNoRuns(t *testing.T) {
+	s := Compute(nil, time.Now())
+	if s.Runs != 0 || len(s.Rates) != len(Windows) || s.Flakiness != 0 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+}
@@ -0,0 +1,50 @@
+package stdstreams
+
+import (
+	"encoding/json"
+	"io"
+	"unicode/utf8"
+)
+
+// WriteCast writes the recording in asciicast v2 format, which can be replayed with
+// asciinema play. See https://docs.asciinema.org/manual/asciicast/v2/.
+func (r *Recording) WriteCast(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	header := struct {
+		Version   int   `json:"version"`
+		Width     int   `json:"width"`
+		Height    int   `json:"height"`
+		Timestamp int64 `json:"timestamp"`
+	}{2, int(r.Cols), int(r.Rows), r.Started.Unix()}
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+
+	var pending []byte // incomplete UTF-8 sequence at the end of the previous frame
+	for _, f := range r.Frames {
+		data := append(pending, f.Data...)
+		n := completeUTF8(data)
+		pending = append([]byte(nil), data[n:]...)
+
+		event := []interface{}{f.Offset.Seconds(), "o", string(data[:n])}
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// completeUTF8 returns the length of data without a trailing incomplete UTF-8 sequence.
+func completeUTF8(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if utf8.FullRune(data[i:]) {
+				return len(data)
+			}
+			return i
+		}
+	}
+	return len(data)
+}
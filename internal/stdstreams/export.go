@@ -0,0 +1,91 @@
+package stdstreams
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// TextOptions control the plain text representation of a Log.
+type TextOptions struct {
+	Timestamps bool // prefix lines with the time they were written
+	Prefix     bool // prefix lines with the name of their stream
+}
+
+// StreamName returns a short name for a stream constant, e.g. "out" for Out.
+func StreamName(stream int) string {
+	switch stream {
+	case Out:
+		return "out"
+	case Err:
+		return "err"
+	case In:
+		return "in"
+	}
+	return "?"
+}
+
+// WriteText writes the plain text of all lines to w, one line per line.
+func (l *Log) WriteText(w io.Writer, opts TextOptions) error {
+	b := bufio.NewWriter(w)
+	for _, line := range l.Lines() {
+		if opts.Timestamps {
+			b.WriteString(line.Time.Format(time.RFC3339Nano))
+			b.WriteByte(' ')
+		}
+		if opts.Prefix {
+			b.WriteString(StreamName(line.Stream))
+			b.WriteString(": ")
+		}
+		b.WriteString(line.Text)
+		b.WriteByte('\n')
+	}
+	return b.Flush()
+}
+
+// WriteJSONL writes all lines to w as JSON Lines, one JSON object per line.
+func (l *Log) WriteJSONL(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	enc := json.NewEncoder(b)
+	for _, ln := range l.Lines() {
+		if err := enc.Encode(plainLine(ln)); err != nil {
+			return err
+		}
+	}
+	return b.Flush()
+}
+
+// jsonLog is the JSON representation of a Log, see Log.MarshalJSON.
+type jsonLog struct {
+	Lines     []plainLine
+	Recording *Recording `json:",omitempty"`
+}
+
+type plainLine Line // encoded without HTML
+
+// MarshalJSON encodes the lines and the recording of the Log, e.g. for moving it between
+// databases. Unlike Log.JSON, lines are encoded without their HTML representation.
+func (l *Log) MarshalJSON() ([]byte, error) {
+	lines := l.Lines()
+	d := jsonLog{Lines: make([]plainLine, len(lines)), Recording: l.Recording()}
+	for i, line := range lines {
+		d.Lines[i] = plainLine(line)
+	}
+	return json.Marshal(d)
+}
+
+// UnmarshalJSON initializes the Log from the representation returned by MarshalJSON.
+func (l *Log) UnmarshalJSON(data []byte) error {
+	var d jsonLog
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+
+	l.lines = make([]Line, len(d.Lines))
+	for i, line := range d.Lines {
+		l.lines[i] = Line(line)
+	}
+	l.rec = d.Recording
+	return nil
+}
@@ -0,0 +1,86 @@
+package stdstreams
+
+import (
+	"encoding/json"
+	"html"
+	"strings"
+)
+
+// HTML returns the line as HTML. Text is escaped and styled spans are wrapped in span
+// elements with ansi-* classes, so the result is safe to insert into a page.
+func (l Line) HTML() string {
+	if len(l.Spans) == 0 {
+		return html.EscapeString(l.Text)
+	}
+
+	var b strings.Builder
+	for _, s := range l.Spans {
+		class, style := s.Style.css()
+		if class == "" && style == "" {
+			b.WriteString(html.EscapeString(s.Text))
+			continue
+		}
+
+		b.WriteString("<span")
+		if class != "" {
+			b.WriteString(` class="` + html.EscapeString(class) + `"`)
+		}
+		if style != "" {
+			b.WriteString(` style="` + html.EscapeString(style) + `"`)
+		}
+		b.WriteString(">")
+		b.WriteString(html.EscapeString(s.Text))
+		b.WriteString("</span>")
+	}
+	return b.String()
+}
+
+// css returns the class names and inline style for s. Palette colors use classes, colors of
+// the form #rrggbb use inline styles and invalid colors are ignored, see Style.Valid.
+func (s Style) css() (class, style string) {
+	var classes, styles []string
+
+	fg, bg := s.FG, s.BG
+	if s.Inverse {
+		fg, bg = bg, fg
+		if fg == "" {
+			classes = append(classes, "ansi-inverse")
+		}
+	}
+
+	color := func(prefix, property, c string) {
+		switch {
+		case c == "" || !validColor(c):
+		case strings.HasPrefix(c, "#"):
+			styles = append(styles, property+":"+c)
+		default:
+			classes = append(classes, prefix+c)
+		}
+	}
+	color("ansi-fg-", "color", fg)
+	color("ansi-bg-", "background-color", bg)
+
+	if s.Bold {
+		classes = append(classes, "ansi-bold")
+	}
+	if s.Dim {
+		classes = append(classes, "ansi-dim")
+	}
+	if s.Italic {
+		classes = append(classes, "ansi-italic")
+	}
+	if s.Underline {
+		classes = append(classes, "ansi-underline")
+	}
+
+	return strings.Join(classes, " "), strings.Join(styles, ";")
+}
+
+// MarshalJSON encodes the line including its HTML representation.
+func (l Line) MarshalJSON() ([]byte, error) {
+	type line Line // prevent recursion
+	return json.Marshal(struct {
+		line
+		HTML string
+	}{line(l), l.HTML()})
+}
@@ -14,21 +14,39 @@ import (
 const (
 	Out = 1
 	Err = 2
+	In  = 3 // input written to the process
 )
 
-// A Line represents a line written to a standard stream.
+// A Line represents a line written to a standard stream. Text is the plain text of the line
+// without escape sequences. If parts of the line are styled, Spans holds the styled parts.
 type Line struct {
 	Stream int
 	Time   time.Time
 	Text   string
+	Spans  []Span `json:",omitempty"`
+}
+
+// A Frame is a chunk of raw output written to a pseudo-terminal.
+type Frame struct {
+	Offset time.Duration // time since the recording started
+	Data   []byte
+}
+
+// A Recording holds the raw output of a process running in a pseudo-terminal together with
+// timing information, so the output can be replayed faithfully.
+type Recording struct {
+	Rows, Cols uint16
+	Started    time.Time
+	Frames     []Frame
 }
 
 // A Log collects output streams.
 type Log struct {
 	lines []Line
 	mutex sync.Mutex
-	outW  *bufferedLineWriter
-	errW  *bufferedLineWriter
+	outW  *terminal
+	errW  *terminal
+	rec   *Recording
 }
 
 // NewLog creates a new log.
@@ -38,6 +56,7 @@ func NewLog() *Log {
 		sync.Mutex{},
 		nil,
 		nil,
+		nil,
 	}
 
 	l.outW = l.makeWriter(Out)
@@ -56,20 +75,69 @@ func (l *Log) Stderr() io.Writer {
 	return l.errW
 }
 
-func (l *Log) makeWriter(stream int) *bufferedLineWriter {
-	return newBufferedLineWriter(func(text string) {
-		l.writeLine(stream, text)
+// Terminal returns an io.Writer for the merged output of a process running in a
+// pseudo-terminal of the given size. Lines are written to the Out stream and the raw output is
+// kept as a Recording.
+func (l *Log) Terminal(rows, cols uint16) io.Writer {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rec = &Recording{Rows: rows, Cols: cols, Started: time.Now()}
+	return recorder{l}
+}
+
+// Recording returns a snapshot of the raw output of a process that ran in a pseudo-terminal,
+// or nil. Frames recorded later are not added to the snapshot.
+func (l *Log) Recording() *Recording {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.rec == nil {
+		return nil
+	}
+	rec := *l.rec
+	return &rec
+}
+
+type recorder struct {
+	l *Log
+}
+
+func (r recorder) Write(p []byte) (int, error) {
+	r.l.mutex.Lock()
+	rec := r.l.rec
+	rec.Frames = append(rec.Frames, Frame{time.Since(rec.Started), append([]byte(nil), p...)})
+	r.l.mutex.Unlock()
+
+	return r.l.outW.Write(p)
+}
+
+// Input records a line of input written to the process.
+func (l *Log) Input(text string) {
+	l.writeLine(In, text, nil)
+}
+
+// Append adds a line written elsewhere, like by a process on a remote agent.
+func (l *Log) Append(line Line) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, line)
+}
+
+func (l *Log) makeWriter(stream int) *terminal {
+	return newTerminal(func(text string, spans []Span) {
+		l.writeLine(stream, text, spans)
 	})
 }
 
-func (l *Log) writeLine(stream int, text string) {
+func (l *Log) writeLine(stream int, text string, spans []Span) {
 	l.mutex.Lock()
 	defer l.mutex.Unlock()
-	l.lines = append(l.lines, Line{stream, time.Now(), text})
+	l.lines = append(l.lines, Line{stream, time.Now(), text, spans})
 }
 
 // Lines returns all lines written to the Log.
 func (l *Log) Lines() []Line {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
 	return l.lines
 }
 
@@ -82,14 +150,21 @@ func (l *Log) Flush() {
 // JSON returns a JSON representation of the lines contained in the Log.
 // A number of lines might be skipped. Useful for polling new lines.
 func (l *Log) JSON(skip int) ([]byte, error) {
-	return json.Marshal(l.lines[skip:])
+	return json.Marshal(l.Lines()[skip:])
+}
+
+// logData is the binary representation of a Log. Logs without recording used to be
+// represented by their lines only.
+type logData struct {
+	Lines     []Line
+	Recording *Recording
 }
 
 // MarshalBinary returns a binary representation of the Log.
 func (l *Log) MarshalBinary() ([]byte, error) {
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
-	if err := enc.Encode(l.lines); err != nil {
+	if err := enc.Encode(logData{l.Lines(), l.Recording()}); err != nil {
 		return nil, err
 	}
 
@@ -98,37 +173,11 @@ func (l *Log) MarshalBinary() ([]byte, error) {
 
 // UnmarshalBinary initializes the Log from the given binary representation.
 func (l *Log) UnmarshalBinary(data []byte) error {
-	r := bytes.NewReader(data)
-	dec := gob.NewDecoder(r)
-	return dec.Decode(&l.lines)
-}
-
-type bufferedLineWriter struct {
-	buf *bytes.Buffer
-	fn  func(text string)
-}
-
-func newBufferedLineWriter(fn func(text string)) *bufferedLineWriter {
-	return &bufferedLineWriter{new(bytes.Buffer), fn}
-}
-
-func (w *bufferedLineWriter) Write(p []byte) (int, error) {
-	n := 0
-	for _, b := range p {
-		n++
-		if b == '\n' {
-			w.fn(w.buf.String())
-			w.buf.Reset()
-		} else {
-			w.buf.WriteByte(b)
-		}
+	var d logData
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&d); err != nil {
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(&l.lines)
 	}
-	return n, nil
-}
 
-func (w *bufferedLineWriter) Flush() {
-	if w.buf.Len() > 0 {
-		w.fn(w.buf.String())
-		w.buf.Reset()
-	}
+	l.lines, l.rec = d.Lines, d.Recording
+	return nil
 }
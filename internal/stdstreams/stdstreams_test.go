@@ -1,6 +1,7 @@
 package stdstreams
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -68,3 +69,227 @@ func TestMarshal(t *testing.T) {
 		t.Errorf("Expected 2 lines, got: %v", len(l2.Lines()))
 	}
 }
+
+func TestCarriageReturn(t *testing.T) {
+	l := NewLog()
+	l.Stdout().Write([]byte("progress 10%\rprogress 50%\rprogress 100%\n"))
+	l.Stdout().Write([]byte("long line\rshort\n"))
+	l.Stdout().Write([]byte("windows\r\n"))
+
+	expected := []string{"progress 100%", "shortline", "windows"}
+	lines := l.Lines()
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %v lines, got: %v", len(expected), len(lines))
+	}
+
+	for i, e := range expected {
+		if lines[i].Text != e {
+			t.Errorf("Expected Text == \"%v\", got: \"%v\"", e, lines[i].Text)
+		}
+	}
+}
+
+func TestEscapeSequences(t *testing.T) {
+	l := NewLog()
+	l.Stdout().Write([]byte("\x1b[1;31mred\x1b[0m plain \x1b[38;5;196mx\x1b[39m\n"))
+	l.Stdout().Write([]byte("\x1b]0;title\x07\x1b[2Kclear<ed>\n"))
+
+	lines := l.Lines()
+	if lines[0].Text != "red plain x" {
+		t.Errorf("Expected Text == \"red plain x\", got: \"%v\"", lines[0].Text)
+	}
+
+	spans := lines[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got: %v", len(spans))
+	}
+	if spans[0].Style != (Style{FG: "1", Bold: true}) {
+		t.Errorf("Unexpected style of first span: %+v", spans[0].Style)
+	}
+	if spans[2].Style.FG != "#ff0000" {
+		t.Errorf("Expected FG == \"#ff0000\", got: \"%v\"", spans[2].Style.FG)
+	}
+
+	html := lines[0].HTML()
+	expected := `<span class="ansi-fg-1 ansi-bold">red</span> plain <span style="color:#ff0000">x</span>`
+	if html != expected {
+		t.Errorf("Expected HTML == %q, got: %q", expected, html)
+	}
+
+	if lines[1].Text != "clear<ed>" || lines[1].HTML() != "clear&lt;ed&gt;" {
+		t.Errorf("Unexpected line: %q, %q", lines[1].Text, lines[1].HTML())
+	}
+}
+
+func TestCursorLimit(t *testing.T) {
+	l := NewLog()
+	l.Stdout().Write([]byte("a\x1b[999999999Gb\n"))
+
+	lines := l.Lines()
+	if len(lines) != 1 || len(lines[0].Text) != maxColumn {
+		t.Fatalf("Expected a line of %v characters, got: %v", maxColumn, lines)
+	}
+	if text := lines[0].Text; text[0] != 'a' || text[maxColumn-1] != 'b' {
+		t.Errorf("Unexpected line: %q...%q", text[:2], text[maxColumn-2:])
+	}
+}
+
+func TestUnterminatedEscape(t *testing.T) {
+	l := NewLog()
+	l.Stdout().Write([]byte("\x1b]0;title"))
+	chunk := []byte(strings.Repeat("x", 1000) + "\n")
+	for i := 0; i < maxEscapeLen/len(chunk)+1; i++ {
+		l.Stdout().Write(chunk)
+	}
+	l.Stdout().Write([]byte("done\n"))
+
+	lines := l.Lines()
+	if len(lines) == 0 || lines[len(lines)-1].Text != "done" {
+		t.Fatalf("Expected output after the unterminated sequence, got: %v lines", len(lines))
+	}
+	if w := l.outW; len(w.pending) != 0 {
+		t.Errorf("Expected no pending bytes, got: %v", len(w.pending))
+	}
+}
+
+func TestInvalidStyle(t *testing.T) {
+	bad := Style{FG: `1"><img src=x onerror=alert(1)>`, BG: "#12345g", Bold: true}
+	if bad.Valid() {
+		t.Errorf("Expected invalid style: %+v", bad)
+	}
+	for _, c := range []string{"", "0", "15", "#00ff7F"} {
+		if !(Style{FG: c}).Valid() {
+			t.Errorf("Expected valid color %q", c)
+		}
+	}
+
+	l := Line{Text: "x", Spans: []Span{{Text: "x", Style: bad}}}
+	if html := l.HTML(); html != `<span class="ansi-bold">x</span>` {
+		t.Errorf("Unexpected HTML: %q", html)
+	}
+}
+
+func TestConcurrentAppend(t *testing.T) {
+	l := NewLog()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			l.Append(Line{Stream: Out, Text: "remote"})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := l.JSON(len(l.Lines())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	if n := len(l.Lines()); n != 100 {
+		t.Errorf("Expected 100 lines, got: %v", n)
+	}
+}
+
+func TestSplitWrites(t *testing.T) {
+	l := NewLog()
+	for _, b := range []byte("\x1b[32mgr\xc3\xbcn\x1b[0m\n") {
+		l.Stdout().Write([]byte{b})
+	}
+
+	lines := l.Lines()
+	if len(lines) != 1 || lines[0].Text != "grün" {
+		t.Fatalf("Expected single line \"grün\", got: %v", lines)
+	}
+	if lines[0].Spans[0].Style.FG != "2" {
+		t.Errorf("Expected FG == \"2\", got: \"%v\"", lines[0].Spans[0].Style.FG)
+	}
+}
+
+func TestRecording(t *testing.T) {
+	l := NewLog()
+	w := l.Terminal(24, 80)
+	w.Write([]byte("hello\r\n"))
+
+	if len(l.Lines()) != 1 || l.Lines()[0].Text != "hello" {
+		t.Errorf("Expected single line \"hello\", got: %v", l.Lines())
+	}
+
+	b, err := l.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	l2 := NewLog()
+	if err := l2.UnmarshalBinary(b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rec := l2.Recording()
+	if rec == nil || rec.Cols != 80 || len(rec.Frames) != 1 {
+		t.Fatalf("Expected recording with one frame, got: %+v", rec)
+	}
+	if string(rec.Frames[0].Data) != "hello\r\n" {
+		t.Errorf("Expected raw frame data, got: %q", rec.Frames[0].Data)
+	}
+}
+
+func TestRecordingConcurrent(t *testing.T) {
+	l := NewLog()
+	w := l.Terminal(24, 80)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			w.Write([]byte("line\r\n"))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		var b strings.Builder
+		if err := l.Recording().WriteCast(&b); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := l.MarshalJSON(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	<-done
+
+	if n := len(l.Recording().Frames); n != 100 {
+		t.Errorf("Expected 100 frames, got: %v", n)
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	l := NewLog()
+	l.Stdout().Write([]byte("\x1b[1mout\x1b[0m\n"))
+	l.Stderr().Write([]byte("err\n"))
+
+	var b strings.Builder
+	if err := l.WriteText(&b, TextOptions{Prefix: true}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "out: out\nerr: err\n"
+	if b.String() != expected {
+		t.Errorf("Expected %q, got: %q", expected, b.String())
+	}
+}
+
+func TestWriteJSONL(t *testing.T) {
+	l := NewLog()
+	l.Stdout().Write([]byte("one\ntwo\n"))
+
+	var b strings.Builder
+	if err := l.WriteJSONL(&b); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got: %q", b.String())
+	}
+	if !strings.Contains(lines[1], `"Text":"two"`) || strings.Contains(lines[1], "HTML") {
+		t.Errorf("Unexpected line: %v", lines[1])
+	}
+}
@@ -0,0 +1,310 @@
+package stdstreams
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxColumn    = 4096 // cursor movements beyond this column are clamped
+	maxEscapeLen = 4096 // longer escape sequences are dropped
+)
+
+// Style describes the appearance of text as set by ANSI SGR escape sequences.
+type Style struct {
+	FG, BG    string // palette index "0" to "15" or "#rrggbb", empty for the default color
+	Bold      bool
+	Dim       bool
+	Italic    bool
+	Underline bool
+	Inverse   bool
+}
+
+// Valid reports whether the colors of s are empty, palette indexes or of the form #rrggbb, as
+// set by the terminal. Styles received from elsewhere, e.g. from agents, must be checked.
+func (s Style) Valid() bool {
+	return validColor(s.FG) && validColor(s.BG)
+}
+
+func validColor(c string) bool {
+	if c == "" {
+		return true
+	}
+	if strings.HasPrefix(c, "#") {
+		_, err := strconv.ParseUint(c[1:], 16, 32)
+		return len(c) == 7 && err == nil
+	}
+	n, err := strconv.Atoi(c)
+	return err == nil && n >= 0 && n <= 15 && c == strconv.Itoa(n)
+}
+
+// A Span is a piece of a Line written in the same style.
+type Span struct {
+	Text  string
+	Style Style
+}
+
+type cell struct {
+	r     rune
+	style Style
+}
+
+// terminal interprets the output of a process like a terminal would, one line at a time.
+// Carriage returns move the cursor back to the start of the line, so progress bars collapse
+// into their final state. SGR escape sequences set the style of the following text; all other
+// escape sequences are dropped.
+type terminal struct {
+	fn      func(text string, spans []Span)
+	cells   []cell
+	cursor  int
+	style   Style
+	pending []byte // incomplete escape sequence or UTF-8 character
+}
+
+func newTerminal(fn func(text string, spans []Span)) *terminal {
+	return &terminal{fn: fn}
+}
+
+func (t *terminal) Write(p []byte) (int, error) {
+	buf := p
+	if len(t.pending) > 0 {
+		buf = append(t.pending, p...)
+		t.pending = nil
+	}
+
+	for len(buf) > 0 {
+		switch b := buf[0]; {
+		case b == '\n':
+			t.newline()
+			buf = buf[1:]
+		case b == '\r':
+			t.cursor = 0
+			buf = buf[1:]
+		case b == '\b':
+			if t.cursor > 0 {
+				t.cursor--
+			}
+			buf = buf[1:]
+		case b == 0x1b:
+			n := t.escape(buf)
+			if n == 0 && len(buf) < maxEscapeLen {
+				t.pending = append([]byte(nil), buf...)
+				return len(p), nil
+			}
+			if n == 0 {
+				n = 2 // unterminated, drop the introducer and print what follows
+			}
+			buf = buf[n:]
+		case b < 0x20 && b != '\t', b == 0x7f:
+			buf = buf[1:] // other control characters
+		default:
+			if !utf8.FullRune(buf) {
+				t.pending = append([]byte(nil), buf...)
+				return len(p), nil
+			}
+			r, n := utf8.DecodeRune(buf)
+			t.put(r)
+			buf = buf[n:]
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush emits the current line if it is not empty.
+func (t *terminal) Flush() {
+	t.pending = nil
+	if len(t.cells) > 0 {
+		t.newline()
+	}
+}
+
+func (t *terminal) put(r rune) {
+	c := cell{r, t.style}
+	if t.cursor < len(t.cells) {
+		t.cells[t.cursor] = c
+	} else {
+		t.cells = append(t.cells, c)
+	}
+	t.cursor++
+}
+
+func (t *terminal) newline() {
+	var text strings.Builder
+	var spans []Span
+	for _, c := range t.cells {
+		text.WriteRune(c.r)
+		if n := len(spans); n > 0 && spans[n-1].Style == c.style {
+			spans[n-1].Text += string(c.r)
+		} else {
+			spans = append(spans, Span{string(c.r), c.style})
+		}
+	}
+
+	if len(spans) == 1 && spans[0].Style == (Style{}) {
+		spans = nil // plain text, no need to store spans
+	}
+
+	t.cells = t.cells[:0]
+	t.cursor = 0
+	t.fn(text.String(), spans)
+}
+
+// escape handles the escape sequence at the start of buf and returns its length, or 0 if buf
+// ends before the sequence is complete or the sequence is longer than maxEscapeLen.
+func (t *terminal) escape(buf []byte) int {
+	if len(buf) < 2 {
+		return 0
+	}
+
+	switch buf[1] {
+	case '[': // CSI: parameters, intermediate bytes and a final byte in 0x40-0x7e
+		for i := 2; i < len(buf) && i < maxEscapeLen; i++ {
+			if buf[i] >= 0x40 && buf[i] <= 0x7e {
+				t.csi(string(buf[2:i]), buf[i])
+				return i + 1
+			}
+		}
+		return 0
+	case ']': // OSC: terminated by BEL or ESC \
+		for i := 2; i < len(buf) && i < maxEscapeLen; i++ {
+			if buf[i] == 0x07 {
+				return i + 1
+			}
+			if buf[i] == 0x1b && i+1 < len(buf) && buf[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return 0
+	default:
+		return 2
+	}
+}
+
+func (t *terminal) csi(params string, final byte) {
+	switch final {
+	case 'm':
+		t.sgr(params)
+	case 'K': // erase in line
+		switch params {
+		case "", "0":
+			if t.cursor < len(t.cells) {
+				t.cells = t.cells[:t.cursor]
+			}
+		case "1":
+			for i := 0; i < t.cursor && i < len(t.cells); i++ {
+				t.cells[i] = cell{' ', t.style}
+			}
+		case "2":
+			t.cells = t.cells[:0]
+		}
+	case 'G': // cursor horizontal absolute
+		n, _ := strconv.Atoi(params)
+		if n < 1 {
+			n = 1
+		}
+		t.cursor = min(n, maxColumn) - 1
+		for len(t.cells) < t.cursor {
+			t.cells = append(t.cells, cell{' ', Style{}})
+		}
+	}
+}
+
+func (t *terminal) sgr(params string) {
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		c, _ := strconv.Atoi(codes[i]) // empty means 0
+		switch {
+		case c == 0:
+			t.style = Style{}
+		case c == 1:
+			t.style.Bold = true
+		case c == 2:
+			t.style.Dim = true
+		case c == 3:
+			t.style.Italic = true
+		case c == 4:
+			t.style.Underline = true
+		case c == 7:
+			t.style.Inverse = true
+		case c == 22:
+			t.style.Bold, t.style.Dim = false, false
+		case c == 23:
+			t.style.Italic = false
+		case c == 24:
+			t.style.Underline = false
+		case c == 27:
+			t.style.Inverse = false
+		case c >= 30 && c <= 37:
+			t.style.FG = strconv.Itoa(c - 30)
+		case c == 39:
+			t.style.FG = ""
+		case c >= 40 && c <= 47:
+			t.style.BG = strconv.Itoa(c - 40)
+		case c == 49:
+			t.style.BG = ""
+		case c >= 90 && c <= 97:
+			t.style.FG = strconv.Itoa(c - 90 + 8)
+		case c >= 100 && c <= 107:
+			t.style.BG = strconv.Itoa(c - 100 + 8)
+		case c == 38 || c == 48:
+			color, n := extendedColor(codes[i+1:])
+			i += n
+			if c == 38 {
+				t.style.FG = color
+			} else {
+				t.style.BG = color
+			}
+		}
+	}
+}
+
+// extendedColor parses the arguments of SGR 38 and 48, i.e. 5;n for the 256 color palette
+// or 2;r;g;b for true colors. It returns the color and the number of arguments consumed.
+func extendedColor(args []string) (string, int) {
+	num := func(i int) int {
+		if i >= len(args) {
+			return 0
+		}
+		n, _ := strconv.Atoi(args[i])
+		if n < 0 || n > 255 {
+			return 0
+		}
+		return n
+	}
+
+	if len(args) == 0 {
+		return "", 0
+	}
+
+	switch args[0] {
+	case "5":
+		return paletteColor(num(1)), 2
+	case "2":
+		return fmt.Sprintf("#%02x%02x%02x", num(1), num(2), num(3)), 4
+	}
+	return "", len(args)
+}
+
+// paletteColor returns the color for an index of the 256 color palette. The first 16 colors
+// are left to the style sheet.
+func paletteColor(n int) string {
+	switch {
+	case n < 16:
+		return strconv.Itoa(n)
+	case n < 232: // 6x6x6 color cube
+		n -= 16
+		level := func(v int) int {
+			if v == 0 {
+				return 0
+			}
+			return 55 + v*40
+		}
+		return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6))
+	default: // grayscale ramp
+		v := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", v, v, v)
+	}
+}
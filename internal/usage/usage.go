@@ -0,0 +1,12 @@
+// Package usage reports the resources used by completed processes.
+package usage
+
+import (
+	"os"
+	"time"
+)
+
+// CPUTime returns the user and system CPU time of a process.
+func CPUTime(ps *os.ProcessState) time.Duration {
+	return ps.UserTime() + ps.SystemTime()
+}
@@ -0,0 +1,14 @@
+package usage
+
+import (
+	"os"
+	"syscall"
+)
+
+// MaxRSS returns the peak resident memory of a process in bytes.
+func MaxRSS(ps *os.ProcessState) int64 {
+	if ru, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		return ru.Maxrss * 1024 // in kilobytes on Linux
+	}
+	return 0
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package usage
+
+import "os"
+
+// MaxRSS returns 0, the peak resident memory is only known on Linux.
+func MaxRSS(ps *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,149 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ngrash/optask/internal/api"
+	"github.com/ngrash/optask/internal/model"
+)
+
+// agent returns the agent authenticated by the bearer token of r, or nil.
+func (s *Server) agent(r *http.Request) *model.Agent {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	for i, a := range s.proj.Agents {
+		if validToken(token, a.Token) {
+			return &s.proj.Agents[i]
+		}
+	}
+	return nil
+}
+
+// authorizeAgent rejects requests without the bearer token of a configured agent.
+func (s *Server) authorizeAgent(h func(http.ResponseWriter, *http.Request, *model.Agent)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a := s.agent(r)
+		if a == nil {
+			s.audit(r, model.AuditDenied, "", "", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="optask"`)
+			http.Error(w, "invalid or missing agent token", http.StatusUnauthorized)
+			return
+		}
+		h(w, r, a)
+	}
+}
+
+// serveAgentConnect keeps the connection of an agent advertising the labels given by the form
+// values label (k=v), streaming api.AgentMessage values as JSON lines.
+func (s *Server) serveAgentConnect(w http.ResponseWriter, r *http.Request, a *model.Agent) {
+	r.ParseForm()
+	labels := make(map[string]string)
+	for _, l := range r.Form["label"] {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok || k == "" {
+			http.Error(w, "invalid label "+l+", expected key=value", http.StatusBadRequest)
+			return
+		}
+		labels[k] = v
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	conn := s.runner.ConnectAgent(a.Name, labels)
+	defer conn.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	send := func(m api.AgentMessage) bool {
+		if err := enc.Encode(m); err != nil {
+			return false
+		}
+		flusher.Flush()
+		conn.Seen()
+		return true
+	}
+
+	ping := time.NewTicker(api.AgentPingInterval)
+	defer ping.Stop()
+	for ok := send(api.AgentMessage{Ping: true}); ok; {
+		select {
+		case m := <-conn.Messages():
+			ok = send(m)
+		case <-ping.C:
+			ok = send(api.AgentMessage{Ping: true})
+		case <-conn.Done():
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveAgentReport reads the api.AgentReport values an agent streams as JSON lines for the run
+// given by the form values t and r.
+func (s *Server) serveAgentReport(w http.ResponseWriter, r *http.Request, a *model.Agent) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	tID := model.TaskID(r.URL.Query().Get("t"))
+	rID := model.RunID(r.URL.Query().Get("r"))
+	dec := json.NewDecoder(r.Body)
+	next := func() (*api.AgentReport, error) {
+		var rep api.AgentReport
+		if err := dec.Decode(&rep); err != nil {
+			return nil, err
+		}
+		return &rep, nil
+	}
+
+	if err := s.runner.ReportRun(a.Name, tID, rID, next); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) serveAPIAgents(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.runner.Agents())
+}
+
+// serveAgents shows whether the agents are connected.
+func (s *Server) serveAgents(w http.ResponseWriter, r *http.Request) {
+	type agentView struct {
+		Name      string
+		Labels    string
+		Connected bool
+		Never     bool          // whether the agent never connected
+		Since     time.Duration // since the agent connected or disconnected
+		LastSeen  time.Duration
+		Runs      int
+	}
+
+	type view struct {
+		Title  string
+		Agents []agentView
+	}
+
+	v := view{Title: s.proj.Name}
+	now := time.Now()
+	for _, a := range s.runner.Agents() {
+		v.Agents = append(v.Agents, agentView{
+			Name:      a.Name,
+			Labels:    model.FormatLabels(a.Labels),
+			Connected: a.Connected,
+			Never:     a.LastSeen.IsZero(),
+			Since:     now.Sub(a.Since).Round(time.Second),
+			LastSeen:  now.Sub(a.LastSeen).Round(time.Second),
+			Runs:      a.Runs,
+		})
+	}
+
+	s.renderTemplate(w, s.template.agents, v)
+}
@@ -0,0 +1,281 @@
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ngrash/optask/internal/api"
+	"github.com/ngrash/optask/internal/model"
+)
+
+const defaultHistoryCount = 50
+
+func (s *Server) handleAPI() {
+	s.mux.HandleFunc("/api/exec", s.authorize(s.serveAPIExec))
+	s.mux.HandleFunc("/api/requests", s.authorize(s.serveAPIRequests))
+	s.mux.HandleFunc("/api/approve", s.authorize(s.serveAPIApprove))
+	s.mux.HandleFunc("/api/reject", s.authorize(s.serveAPIReject))
+	s.mux.HandleFunc("/api/freeze", s.authorize(s.serveAPIFreeze))
+	s.mux.HandleFunc("/api/cancel", s.authorize(s.serveAPICancel))
+	s.mux.HandleFunc("/api/run", s.authorize(s.serveAPIRun))
+	s.mux.HandleFunc("/api/history", s.authorize(s.serveAPIHistory))
+	s.mux.HandleFunc("/api/status", s.authorize(s.serveAPIStatus))
+	s.mux.HandleFunc("/api/stdstreams", s.authorize(s.serveStdstreams))
+	s.mux.HandleFunc("/api/search", s.authorize(s.serveAPISearch))
+	s.mux.HandleFunc("/api/stdin", s.authorize(s.serveStdin))
+	s.mux.HandleFunc("/api/download", s.authorize(s.serveDownload))
+	s.mux.HandleFunc("/api/artifact", s.authorize(s.serveArtifact))
+	s.mux.HandleFunc("/api/export", s.authorize(s.serveExport))
+	s.mux.HandleFunc("/api/backup", s.authorize(s.serveAPIBackup))
+	s.mux.HandleFunc("/api/stats", s.authorize(s.serveAPIStats))
+	s.mux.HandleFunc("/api/audit", s.authorize(s.serveAPIAudit))
+	s.mux.HandleFunc("/api/agents", s.authorize(s.serveAPIAgents))
+	s.mux.HandleFunc("/api/agent/connect", s.authorizeAgent(s.serveAgentConnect))
+	s.mux.HandleFunc("/api/agent/report", s.authorizeAgent(s.serveAgentReport))
+}
+
+// authorize rejects requests without the bearer token of a configured user. If the project
+// has no users, all requests are allowed.
+func (s *Server) authorize(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.proj.Users) > 0 && s.user(r) == nil {
+			s.audit(r, model.AuditDenied, "", "", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="optask"`)
+			http.Error(w, "invalid or missing token", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// user returns the user authenticated by the bearer token of r or, in the web interface, the
+// token cookie set when logging in. It returns nil if there is no such user.
+func (s *Server) user(r *http.Request) *model.User {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if c, err := r.Cookie(tokenCookie); err == nil && token == "" {
+		token = c.Value
+	}
+	for i, u := range s.proj.Users {
+		if validToken(token, u.Token) {
+			return &s.proj.Users[i]
+		}
+	}
+	return nil
+}
+
+// validToken reports whether token is set and equals want. Tokens are compared in constant
+// time so response times do not reveal them.
+func validToken(token, want string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// paramNames lists the names of run parameters for the audit log. Values might be secret.
+func paramNames(params map[string]string) string {
+	if len(params) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(params))
+	for k := range params {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return "parameters " + strings.Join(names, ", ")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func (s *Server) apiRun(tID model.TaskID, r *model.Run) *api.Run {
+	return &api.Run{Run: *r, TaskID: tID, Running: s.runner.IsRunning(tID, r.ID)}
+}
+
+func (s *Server) serveAPIExec(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	r.ParseForm()
+	tID := model.TaskID(r.Form.Get("t"))
+	task, err := s.runner.Task(tID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	params := make(map[string]string)
+	for _, p := range r.Form["p"] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			http.Error(w, "invalid parameter "+strconv.Quote(p), http.StatusBadRequest)
+			return
+		}
+		params[kv[0]] = kv[1]
+	}
+
+	if task.Approval {
+		req, ok := s.requestExec(w, r, tID, params)
+		if ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			writeJSON(w, api.ExecResult{TaskID: tID, Request: req})
+		}
+		return
+	}
+
+	rID, detail, ok := s.exec(w, r, task, params)
+	if !ok {
+		return
+	}
+	if names := paramNames(params); names != "" {
+		detail = strings.TrimPrefix(detail+", "+names, ", ")
+	}
+	s.audit(r, model.AuditExec, tID, rID, detail)
+
+	writeJSON(w, api.ExecResult{TaskID: tID, RunID: rID})
+}
+
+func (s *Server) serveAPICancel(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	r.ParseForm()
+	tID := model.TaskID(r.Form.Get("t"))
+	rID := model.RunID(r.Form.Get("r"))
+	if err := s.runner.Cancel(tID, rID); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.audit(r, model.AuditCancel, tID, rID, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) serveAPIRun(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	tID := model.TaskID(r.Form.Get("t"))
+	rID := model.RunID(r.Form.Get("r"))
+
+	run, err := s.runner.Run(tID, rID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, s.apiRun(tID, run))
+}
+
+func (s *Server) serveAPIHistory(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	tID := model.TaskID(r.Form.Get("t"))
+	before := model.RunID(r.Form.Get("b"))
+
+	if _, err := s.runner.Task(tID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	count := defaultHistoryCount
+	if n := r.Form.Get("n"); n != "" {
+		var err error
+		if count, err = strconv.Atoi(n); err != nil || count < 1 {
+			http.Error(w, "invalid count "+strconv.Quote(n), http.StatusBadRequest)
+			return
+		}
+	}
+
+	runs, err := s.runner.Runs(tID, before, count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ret := make([]*api.Run, len(runs))
+	for i, run := range runs {
+		ret[i] = s.apiRun(tID, run)
+	}
+
+	writeJSON(w, ret)
+}
+
+// serveAPIStatus returns all tasks with their latest run or, if the form value tag is set,
+// only tasks with that tag.
+func (s *Server) serveAPIStatus(w http.ResponseWriter, r *http.Request) {
+	runs, err := s.runner.LatestRuns()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	r.ParseForm()
+	tag := r.Form.Get("tag")
+
+	tasks := make([]*api.Task, 0, len(s.proj.Tasks))
+	for _, t := range s.proj.Tasks {
+		if tag != "" && !hasTag(t.Tags, tag) {
+			continue
+		}
+		at := &api.Task{ID: t.ID, Name: t.Name, Description: t.Description, Group: t.Group, Tags: t.Tags}
+		if run := runs[t.ID]; run != nil {
+			at.LastRun = s.apiRun(t.ID, run)
+		}
+		tasks = append(tasks, at)
+	}
+
+	writeJSON(w, tasks)
+}
+
+// serveAPIBackup serves a consistent copy of the database while runs continue. The form value
+// format selects a copy of the database file (db, the default) or the portable export
+// format (jsonl). If the backup fails after sending has started, the trailer Optask-Error
+// holds the error.
+func (s *Server) serveAPIBackup(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	name := fmt.Sprintf("%v-%v", s.proj.ID, time.Now().Format("20060102-150405"))
+	w.Header().Set("Trailer", "Optask-Error")
+
+	format := r.Form.Get("format")
+	if format == "" {
+		format = "db"
+	}
+	s.audit(r, model.AuditBackup, "", "", "format "+format)
+
+	var err error
+	switch format {
+	case "db":
+		attachment(w, "application/octet-stream", name+s.runner.DatabaseExtension())
+		_, err = s.runner.Backup(w)
+	case "jsonl":
+		attachment(w, "application/x-ndjson", name+".jsonl")
+		err = s.runner.Export(w)
+	default:
+		http.Error(w, "unknown format "+strconv.Quote(format), http.StatusBadRequest)
+		return
+	}
+
+	// headers are sent already, so errors are reported in a trailer for clients to check
+	if err != nil {
+		log.Printf("backing up database: %v", err)
+		w.Header().Set("Optask-Error", err.Error())
+	}
+}
@@ -0,0 +1,115 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/ngrash/optask/internal/api"
+	"github.com/ngrash/optask/internal/model"
+)
+
+// requestExec requests a run of a task requiring approval on behalf of the user of r. It
+// writes an error and returns false if that fails.
+func (s *Server) requestExec(w http.ResponseWriter, r *http.Request, tID model.TaskID, params map[string]string) (*model.Request, bool) {
+	u := s.user(r)
+	if u == nil {
+		s.audit(r, model.AuditDenied, tID, "", r.URL.Path)
+		http.Error(w, "log in to request runs of this task", http.StatusForbidden)
+		return nil, false
+	}
+
+	req, err := s.runner.RequestExec(tID, params, u.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	s.audit(r, model.AuditRequest, tID, "", requestDetail(req))
+	return req, true
+}
+
+func requestDetail(req *model.Request) string {
+	d := "request " + req.ID
+	if names := paramNames(req.Params); names != "" {
+		d += " with " + names
+	}
+	return d
+}
+
+// decide approves or rejects the request given by the form value id on behalf of the user
+// of r. It writes an error and returns false if that fails.
+func (s *Server) decide(w http.ResponseWriter, r *http.Request, approve bool) (*model.Request, model.RunID, bool) {
+	if !requirePost(w, r) {
+		return nil, "", false
+	}
+
+	u := s.user(r)
+	if u == nil {
+		s.audit(r, model.AuditDenied, "", "", r.URL.Path)
+		http.Error(w, "log in to approve or reject requests", http.StatusForbidden)
+		return nil, "", false
+	}
+
+	r.ParseForm()
+	id := r.Form.Get("id")
+	override, ok := s.override(w, r)
+	if !ok {
+		return nil, "", false
+	}
+
+	var req *model.Request
+	var rID model.RunID
+	var err error
+	action := model.AuditReject
+	if approve {
+		action = model.AuditApprove
+		req, rID, err = s.runner.Approve(id, u.Name, override)
+	} else {
+		req, err = s.runner.Reject(id)
+	}
+	if req == nil {
+		if !s.blockedError(w, r, err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+		return nil, "", false
+	}
+
+	detail := "request " + req.ID + " by " + req.User
+	if override {
+		detail += ", override"
+	}
+	s.audit(r, action, req.TaskID, rID, detail)
+	if err != nil { // approved, but the run did not start
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, "", false
+	}
+	return req, rID, true
+}
+
+func (s *Server) serveApprove(w http.ResponseWriter, r *http.Request) {
+	req, rID, ok := s.decide(w, r, true)
+	if ok {
+		http.Redirect(w, r, s.url("show?t="+string(req.TaskID)+"&r="+string(rID)), http.StatusSeeOther)
+	}
+}
+
+func (s *Server) serveReject(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := s.decide(w, r, false); ok {
+		http.Redirect(w, r, s.url(""), http.StatusSeeOther)
+	}
+}
+
+func (s *Server) serveAPIRequests(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.runner.Requests())
+}
+
+func (s *Server) serveAPIApprove(w http.ResponseWriter, r *http.Request) {
+	req, rID, ok := s.decide(w, r, true)
+	if ok {
+		writeJSON(w, api.ExecResult{TaskID: req.TaskID, RunID: rID})
+	}
+}
+
+func (s *Server) serveAPIReject(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := s.decide(w, r, false); ok {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,39 @@
+package web
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	assets "github.com/ngrash/optask/web"
+)
+
+// devDir is the directory templates and static assets are read from in development mode.
+const devDir = "web"
+
+// assetFS returns the file system containing the tmpl and static directories. Files in the
+// override directory take precedence over the embedded or development assets.
+func assetFS(opts Options) fs.FS {
+	var base fs.FS = assets.FS
+	if opts.Dev {
+		base = os.DirFS(devDir)
+	}
+
+	if opts.OverrideDir == "" {
+		return base
+	}
+	return overlayFS{os.DirFS(opts.OverrideDir), base}
+}
+
+// overlayFS serves files from upper if they exist there and from lower otherwise.
+type overlayFS struct {
+	upper, lower fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+	return f, err
+}
@@ -0,0 +1,247 @@
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ngrash/optask/internal/db"
+	"github.com/ngrash/optask/internal/model"
+)
+
+const (
+	tokenCookie   = "optask_token" // holds the token of a user logged in to the web interface
+	auditPageSize = 100            // number of audit entries per page and read at once when exporting
+)
+
+// auditActions are the actions that can be selected on the audit page.
+var auditActions = []string{
+	model.AuditStart, model.AuditExec, model.AuditCancel, model.AuditRequest, model.AuditApprove,
+	model.AuditReject, model.AuditExpire, model.AuditInput, model.AuditLogin,
+	model.AuditLoginFailed, model.AuditLogout, model.AuditDenied, model.AuditBackup,
+	model.AuditRestore, model.AuditImport, model.AuditExport, model.AuditFreeze,
+	model.AuditUnfreeze,
+}
+
+// audit records an action of the user of r in the audit log.
+func (s *Server) audit(r *http.Request, action string, tID model.TaskID, rID model.RunID, detail string) {
+	e := &model.AuditEntry{RemoteAddr: r.RemoteAddr, Action: action, TaskID: tID, RunID: rID, Detail: detail}
+	if u := s.user(r); u != nil {
+		e.User = u.Name
+	}
+	s.recordAudit(e)
+}
+
+func (s *Server) recordAudit(e *model.AuditEntry) {
+	if err := s.runner.RecordAudit(e); err != nil {
+		log.Printf("recording %v in audit log: %v", e.Action, err)
+	}
+}
+
+// requireAdmin rejects requests of users who are not admins. If the project has no users, all
+// requests are allowed.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.proj.Users) == 0 {
+		return true
+	}
+	if u := s.user(r); u == nil || !u.Admin {
+		s.audit(r, model.AuditDenied, "", "", r.URL.Path)
+		http.Error(w, "admin permission required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireLogin redirects requests of the web interface without a logged-in user to the login
+// page, or rejects them if they change something. If the project has no users, all requests
+// are allowed.
+func (s *Server) requireLogin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.proj.Users) > 0 && s.user(r) == nil {
+			s.audit(r, model.AuditDenied, "", "", r.URL.Path)
+			if r.Method == "GET" || r.Method == "HEAD" {
+				http.Redirect(w, r, s.url("login"), http.StatusSeeOther)
+			} else {
+				http.Error(w, "login required", http.StatusUnauthorized)
+			}
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) serveLogin(w http.ResponseWriter, r *http.Request) {
+	type view struct {
+		Title string
+		Error string
+	}
+	v := view{Title: s.proj.Name}
+
+	if r.Method == "POST" {
+		r.ParseForm()
+		token := r.Form.Get("token")
+		for _, u := range s.proj.Users {
+			if validToken(token, u.Token) {
+				http.SetCookie(w, &http.Cookie{
+					Name:     tokenCookie,
+					Value:    token,
+					Path:     s.opts.BasePath,
+					HttpOnly: true,
+					Secure:   r.TLS != nil,
+					SameSite: http.SameSiteStrictMode,
+				})
+				s.recordAudit(&model.AuditEntry{User: u.Name, RemoteAddr: r.RemoteAddr, Action: model.AuditLogin})
+				http.Redirect(w, r, s.url(""), http.StatusSeeOther)
+				return
+			}
+		}
+
+		s.audit(r, model.AuditLoginFailed, "", "", "")
+		v.Error = "Unknown token."
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	s.renderTemplate(w, s.template.login, v)
+}
+
+func (s *Server) serveLogout(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	s.audit(r, model.AuditLogout, "", "", "")
+	http.SetCookie(w, &http.Cookie{Name: tokenCookie, Path: s.opts.BasePath, MaxAge: -1})
+	http.Redirect(w, r, s.url(""), http.StatusSeeOther)
+}
+
+// auditFilter reads a filter from the form values u (user), a (action), t (task), since and
+// until (dates as YYYY-MM-DD, both inclusive) and b (entries before that ID).
+func auditFilter(form url.Values) (db.AuditFilter, error) {
+	f := db.AuditFilter{
+		User:   form.Get("u"),
+		Action: form.Get("a"),
+		TaskID: model.TaskID(form.Get("t")),
+	}
+
+	var err error
+	if v := form.Get("since"); v != "" {
+		if f.Since, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			return f, err
+		}
+	}
+	if v := form.Get("until"); v != "" {
+		if f.Until, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			return f, err
+		}
+		f.Until = f.Until.AddDate(0, 0, 1)
+	}
+	if v := form.Get("b"); v != "" {
+		if f.Before, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// serveAudit serves the audit log as a page or, if the form value format is jsonl, all
+// entries matching the filter as JSON Lines.
+func (s *Server) serveAudit(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	r.ParseForm()
+	f, err := auditFilter(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Form.Get("format") == "jsonl" {
+		s.audit(r, model.AuditExport, f.TaskID, "", r.URL.RawQuery)
+		attachment(w, "application/x-ndjson", "audit-"+time.Now().Format("20060102-150405")+".jsonl")
+		enc := json.NewEncoder(w)
+		for {
+			entries, err := s.runner.AuditLog(f, auditPageSize)
+			if err != nil {
+				log.Panic(err)
+			}
+			for _, e := range entries {
+				if err := enc.Encode(e); err != nil {
+					return
+				}
+			}
+			if len(entries) < auditPageSize {
+				return
+			}
+			f.Before = entries[len(entries)-1].ID
+		}
+	}
+
+	entries, err := s.runner.AuditLog(f, auditPageSize)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	type view struct {
+		Title   string
+		Entries []*model.AuditEntry
+		Form    url.Values
+		Actions []string
+		Tasks   []model.Task
+		Export  string // query of the export link
+		Next    string // query of the next page, empty if there is none
+	}
+
+	query := url.Values{}
+	for _, k := range []string{"u", "a", "t", "since", "until"} {
+		if v := r.Form.Get(k); v != "" {
+			query.Set(k, v)
+		}
+	}
+
+	v := view{
+		Title:   s.proj.Name,
+		Entries: entries,
+		Form:    r.Form,
+		Actions: auditActions,
+		Tasks:   s.runner.ListTasks(),
+	}
+	query.Set("format", "jsonl")
+	v.Export = query.Encode()
+	query.Del("format")
+	if len(entries) == auditPageSize {
+		query.Set("b", strconv.FormatUint(entries[len(entries)-1].ID, 10))
+		v.Next = query.Encode()
+	}
+
+	s.renderTemplate(w, s.template.audit, v)
+}
+
+func (s *Server) serveAPIAudit(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	r.ParseForm()
+	f, err := auditFilter(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	count := auditPageSize
+	if n, err := strconv.Atoi(r.Form.Get("n")); err == nil && n > 0 {
+		count = n
+	}
+
+	entries, err := s.runner.AuditLog(f, count)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, entries)
+}
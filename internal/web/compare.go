@@ -0,0 +1,150 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/ngrash/optask/internal/diff"
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+// compareContext is the number of unchanged lines shown around changes.
+const compareContext = 3
+
+// normalize returns the text of lines with all matches of ignore removed, so volatile parts
+// like timestamps do not show up as changes.
+func normalize(lines []stdstreams.Line, ignore []*regexp.Regexp) []string {
+	ret := make([]string, len(lines))
+	for i, l := range lines {
+		text := l.Text
+		for _, re := range ignore {
+			text = re.ReplaceAllString(text, "")
+		}
+		ret[i] = text
+	}
+	return ret
+}
+
+func (s *Server) serveCompare(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	tID := model.TaskID(r.Form.Get("t"))
+	aID := model.RunID(r.Form.Get("a"))
+	bID := model.RunID(r.Form.Get("b"))
+
+	task, err := s.runner.Task(tID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if aID == "" {
+		prev, err := s.runner.Runs(tID, bID, 1)
+		if err != nil {
+			log.Panic(err)
+		}
+		if len(prev) == 0 {
+			http.Error(w, "no previous run to compare with", http.StatusNotFound)
+			return
+		}
+		aID = prev[0].ID
+	}
+
+	type runView struct {
+		ID       model.RunID
+		TaskID   model.TaskID
+		Running  bool
+		Canceled bool
+		Outcome  model.Outcome
+		ExitCode int
+		Started  time.Time
+		Duration time.Duration // run time, not time since completion
+	}
+
+	type lineView struct {
+		Op    string // " ", "-" or "+"
+		Class string
+		A, B  int // 1-based line numbers, 0 if the line is not in that run
+		Line  stdstreams.Line
+	}
+
+	type view struct {
+		Title        string
+		Task         model.Task
+		A, B         runView
+		ExitChanged  bool
+		DurationDiff time.Duration
+		Hunks        [][]lineView
+		Ignored      []string
+	}
+
+	var runs [2]runView
+	var lines [2][]stdstreams.Line
+	for i, rID := range []model.RunID{aID, bID} {
+		run, err := s.runner.Run(tID, rID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		streams, err := s.runner.StdStreams(tID, rID)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		rv := runView{
+			ID:       rID,
+			TaskID:   tID,
+			Running:  s.runner.IsRunning(tID, rID),
+			Canceled: run.Canceled,
+			Outcome:  run.Outcome,
+			ExitCode: run.ExitCode,
+			Started:  run.Started,
+		}
+		if rv.Running {
+			rv.Duration = time.Since(run.Started)
+		} else {
+			rv.Duration = run.Completed.Sub(run.Started)
+		}
+		rv.Duration = roundDuration(rv.Duration)
+		runs[i] = rv
+		lines[i] = streams.Lines()
+	}
+
+	// patterns were validated with the configuration
+	ignore := make([]*regexp.Regexp, len(task.DiffIgnore))
+	for i, expr := range task.DiffIgnore {
+		ignore[i] = regexp.MustCompile(expr)
+	}
+
+	edits := diff.Lines(normalize(lines[0], ignore), normalize(lines[1], ignore))
+
+	v := view{
+		Title:        s.proj.Name,
+		Task:         task,
+		A:            runs[0],
+		B:            runs[1],
+		ExitChanged:  runs[0].ExitCode != runs[1].ExitCode,
+		DurationDiff: runs[1].Duration - runs[0].Duration,
+		Ignored:      task.DiffIgnore,
+	}
+	for _, hunk := range diff.Hunks(edits, compareContext) {
+		var lvs []lineView
+		for _, e := range hunk {
+			lv := lineView{A: e.A + 1, B: e.B + 1}
+			switch e.Op {
+			case diff.Equal:
+				lv.Op, lv.Class, lv.Line = " ", "diff-equal", lines[1][e.B]
+			case diff.Delete:
+				lv.Op, lv.Class, lv.Line = "-", "diff-delete", lines[0][e.A]
+			case diff.Insert:
+				lv.Op, lv.Class, lv.Line = "+", "diff-insert", lines[1][e.B]
+			}
+			lvs = append(lvs, lv)
+		}
+		v.Hunks = append(v.Hunks, lvs)
+	}
+
+	s.renderTemplate(w, s.template.compare, v)
+}
@@ -0,0 +1,227 @@
+package web
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/ngrash/optask/internal/api"
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+const exportPageSize = 100 // number of runs read at once when exporting a task
+
+func attachment(w http.ResponseWriter, contentType, name string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(name))
+}
+
+// serveDownload serves the log of a run as plain text (format txt, the default), JSON Lines
+// (jsonl) or a self-contained HTML page (html). Plain text lines are prefixed with their time
+// if the form value ts is set and with their stream if prefix is set.
+func (s *Server) serveDownload(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	tID := model.TaskID(r.Form.Get("t"))
+	rID := model.RunID(r.Form.Get("r"))
+
+	task, err := s.runner.Task(tID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	run, err := s.runner.Run(tID, rID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	streams, err := s.runner.StdStreams(tID, rID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	name := fmt.Sprintf("%v-%v", tID, rID)
+	switch format := r.Form.Get("format"); format {
+	case "", "txt":
+		opts := stdstreams.TextOptions{
+			Timestamps: r.Form.Get("ts") != "",
+			Prefix:     r.Form.Get("prefix") != "",
+		}
+		attachment(w, "text/plain; charset=utf-8", name+".txt")
+		handleErrorMaybe(w, streams.WriteText(w, opts))
+	case "jsonl":
+		attachment(w, "application/x-ndjson", name+".jsonl")
+		handleErrorMaybe(w, streams.WriteJSONL(w))
+	case "html":
+		style, err := fs.ReadFile(s.assets, path.Join("static", "style.css"))
+		if err != nil {
+			log.Panic(err)
+		}
+
+		v := struct {
+			Title   string
+			Task    model.Task
+			Run     *model.Run
+			Running bool
+			Lines   []stdstreams.Line
+			Style   template.CSS
+		}{s.proj.Name, task, run, s.runner.IsRunning(tID, rID), streams.Lines(), template.CSS(style)}
+
+		var buf bytes.Buffer
+		if err := s.template.snapshot.Execute(&buf, v); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		attachment(w, "text/html; charset=utf-8", name+".html")
+		buf.WriteTo(w)
+	default:
+		http.Error(w, "unknown format "+strconv.Quote(format), http.StatusBadRequest)
+	}
+}
+
+// formatBytes formats a size in bytes using binary prefixes, e.g. 1.5 KiB.
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	f := float64(n)
+	unit := 0
+	for f >= 1024 && unit < 4 {
+		f /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", f, "KMGT"[unit-1])
+}
+
+// serveArtifact serves the artifact name of a run. The checksum of the file is sent in the
+// Optask-SHA256 header.
+func (s *Server) serveArtifact(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	tID := model.TaskID(r.Form.Get("t"))
+	rID := model.RunID(r.Form.Get("r"))
+	name := r.Form.Get("name")
+
+	file, err := s.runner.Artifact(tID, rID, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	run, _ := s.runner.Run(tID, rID)
+	for _, a := range run.Artifacts {
+		if a.Name == name {
+			w.Header().Set("Optask-SHA256", a.SHA256)
+		}
+	}
+	attachment(w, "application/octet-stream", path.Base(name))
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// serveExport serves the history of a task as a gzipped tar archive. The archive contains
+// runs.json with all runs and, for each run, its log as <run>.txt and <run>.jsonl.
+func (s *Server) serveExport(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	tID := model.TaskID(r.Form.Get("t"))
+	if _, err := s.runner.Task(tID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var runs []*api.Run
+	before := model.RunID("")
+	for {
+		page, err := s.runner.Runs(tID, before, exportPageSize)
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, run := range page {
+			runs = append(runs, s.apiRun(tID, run))
+		}
+		if len(page) < exportPageSize {
+			break
+		}
+		before = page[len(page)-1].ID
+	}
+
+	dir := fmt.Sprintf("%v-%v", tID, time.Now().Format("20060102-150405"))
+	attachment(w, "application/gzip", dir+".tar.gz")
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: path.Join(dir, name), Mode: 0644, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	// headers are sent already, so errors can only be logged
+	err := func() error {
+		data, err := json.MarshalIndent(runs, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := add("runs.json", data); err != nil {
+			return err
+		}
+
+		for _, run := range runs {
+			streams, err := s.runner.StdStreams(tID, run.ID)
+			if err != nil {
+				return err
+			}
+
+			var txt, jsonl bytes.Buffer
+			if err := streams.WriteText(&txt, stdstreams.TextOptions{Timestamps: true, Prefix: true}); err != nil {
+				return err
+			}
+			if err := streams.WriteJSONL(&jsonl); err != nil {
+				return err
+			}
+			if err := add(string(run.ID)+".txt", txt.Bytes()); err != nil {
+				return err
+			}
+			if err := add(string(run.ID)+".jsonl", jsonl.Bytes()); err != nil {
+				return err
+			}
+		}
+
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	}()
+	if err != nil {
+		log.Printf("exporting history of %v: %v", tID, err)
+	}
+}
@@ -0,0 +1,134 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/runner"
+)
+
+// canOverride reports whether the user of r may start runs of blocked tasks. If the project has
+// no users, everyone may.
+func (s *Server) canOverride(r *http.Request) bool {
+	if len(s.proj.Users) == 0 {
+		return true
+	}
+	u := s.user(r)
+	return u != nil && u.Override
+}
+
+// override reports whether the form value override is set. It writes an error and returns
+// false as second value if the user of r may not override blocks.
+func (s *Server) override(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	if r.Form.Get("override") == "" {
+		return false, true
+	}
+	if !s.canOverride(r) {
+		s.audit(r, model.AuditDenied, model.TaskID(r.Form.Get("t")), "", r.URL.Path+" override")
+		http.Error(w, "override permission required", http.StatusForbidden)
+		return false, false
+	}
+	return true, true
+}
+
+// userName returns the name of the user of r, or an empty string if there is none.
+func (s *Server) userName(r *http.Request) string {
+	if u := s.user(r); u != nil {
+		return u.Name
+	}
+	return ""
+}
+
+// exec starts a run of task, overriding blocks if the form value override is set. It returns
+// the detail to record in the audit log, or writes an error and returns false if that fails.
+func (s *Server) exec(w http.ResponseWriter, r *http.Request, task model.Task, params map[string]string) (model.RunID, string, bool) {
+	override, ok := s.override(w, r)
+	if !ok {
+		return "", "", false
+	}
+
+	var rID model.RunID
+	var err error
+	var detail string
+	if override {
+		detail = "override"
+		if reason := s.runner.Blocked(task, time.Now()); reason != "" {
+			detail += ": " + reason
+		}
+		rID, err = s.runner.ExecOverride(task.ID, params, s.userName(r))
+	} else {
+		rID, err = s.runner.Exec(task.ID, params)
+	}
+	if err != nil {
+		if !s.blockedError(w, r, err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return "", "", false
+	}
+	return rID, detail, true
+}
+
+// blockedError writes err with status 409 if it is a *runner.BlockedError and reports whether
+// it did.
+func (s *Server) blockedError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var blocked *runner.BlockedError
+	if !errors.As(err, &blocked) {
+		return false
+	}
+	msg := err.Error()
+	if s.canOverride(r) {
+		msg += "; override to start the run anyway"
+	}
+	http.Error(w, msg, http.StatusConflict)
+	return true
+}
+
+// freeze declares a freeze with the form value reason or, if the form value lift is set,
+// lifts the current one. It writes an error and returns false if that fails.
+func (s *Server) freeze(w http.ResponseWriter, r *http.Request) bool {
+	if !requirePost(w, r) || !s.requireAdmin(w, r) {
+		return false
+	}
+
+	r.ParseForm()
+	if r.Form.Get("lift") != "" {
+		if err := s.runner.Unfreeze(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		s.audit(r, model.AuditUnfreeze, "", "", "")
+		return true
+	}
+
+	reason := r.Form.Get("reason")
+	if reason == "" {
+		http.Error(w, "missing reason", http.StatusBadRequest)
+		return false
+	}
+	f := model.Freeze{Reason: reason, User: s.userName(r), Since: time.Now()}
+	if err := s.runner.Freeze(f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	s.audit(r, model.AuditFreeze, "", "", reason)
+	return true
+}
+
+func (s *Server) serveFreeze(w http.ResponseWriter, r *http.Request) {
+	if s.freeze(w, r) {
+		http.Redirect(w, r, s.url(""), http.StatusSeeOther)
+	}
+}
+
+// serveAPIFreeze returns the current freeze, null if there is none, or changes it on POST.
+func (s *Server) serveAPIFreeze(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		writeJSON(w, s.runner.Frozen())
+		return
+	}
+	if s.freeze(w, r) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,309 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ngrash/optask/internal/api"
+	"github.com/ngrash/optask/internal/model"
+)
+
+type indexRun struct {
+	ID       string
+	TaskID   string
+	ExitCode int
+	Running  bool
+	Canceled bool
+	Outcome  model.Outcome
+	Started  time.Time
+	Duration time.Duration
+	Exists   bool
+	Summary  string
+	Results  []model.Result
+}
+
+type indexTask struct {
+	ID, Name    string
+	Description string
+	Group       string
+	Tags        []string
+	Target      string // labels of the agents running the task, see model.FormatLabels
+	Confirm     bool
+	Approval    bool
+	Blocked     string // why runs must not start now, see runner.Service.Blocked
+	Override    bool   // whether the user may start runs anyway
+	Pinned      bool
+	CanPin      bool   // whether the user may pin tasks
+	Query       string // query of the index page, to return to it after pinning
+	LastRun     indexRun
+}
+
+type indexGroup struct {
+	Name  string
+	Tasks []indexTask
+}
+
+// Status filters of the index page.
+const (
+	filterFailing = "failing" // the latest run failed or optask failed to run it
+	filterRunning = "running"
+	filterNever   = "never" // the task never ran
+)
+
+// Orders of the index page. Tasks are listed in the order of the config by default.
+const (
+	sortName   = "name"
+	sortRecent = "recent" // most recently started first
+	sortStatus = "status" // running first, then failing, warnings, succeeded and never ran
+)
+
+// matchTask reports whether t matches the filters of the index page: the form values q (text
+// in the ID, name, description, group or tags), tag and status.
+func matchTask(t indexTask, form url.Values) bool {
+	if q := strings.ToLower(form.Get("q")); q != "" {
+		text := strings.ToLower(strings.Join(append([]string{t.ID, t.Name, t.Description, t.Group}, t.Tags...), " "))
+		if !strings.Contains(text, q) {
+			return false
+		}
+	}
+
+	if tag := form.Get("tag"); tag != "" && !hasTag(t.Tags, tag) {
+		return false
+	}
+
+	switch form.Get("status") {
+	case filterFailing:
+		return t.LastRun.Exists && !t.LastRun.Running && (t.LastRun.Outcome == model.Failed || t.LastRun.Outcome == model.Error)
+	case filterRunning:
+		return t.LastRun.Running
+	case filterNever:
+		return !t.LastRun.Exists
+	}
+	return true
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func statusRank(r indexRun) int {
+	switch {
+	case r.Running:
+		return 0
+	case !r.Exists:
+		return 5
+	case r.Outcome == model.Failed || r.Outcome == model.Error:
+		return 1
+	case r.Outcome == model.Canceled:
+		return 2
+	case r.Outcome == model.Warning:
+		return 3
+	}
+	return 4
+}
+
+// sortTasks sorts tasks by order, keeping the order of the config for equal tasks.
+func sortTasks(tasks []indexTask, order string) {
+	var less func(a, b indexTask) bool
+	switch order {
+	case sortName:
+		less = func(a, b indexTask) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case sortRecent:
+		less = func(a, b indexTask) bool { return a.LastRun.Started.After(b.LastRun.Started) }
+	case sortStatus:
+		less = func(a, b indexTask) bool { return statusRank(a.LastRun) < statusRank(b.LastRun) }
+	default:
+		return
+	}
+	sort.SliceStable(tasks, func(i, j int) bool { return less(tasks[i], tasks[j]) })
+}
+
+// groupTasks groups tasks in the order their groups first appear. Tasks without a group come
+// first.
+func groupTasks(tasks []indexTask) []indexGroup {
+	groups := []indexGroup{{}}
+	index := map[string]int{"": 0}
+	for _, t := range tasks {
+		i, ok := index[t.Group]
+		if !ok {
+			i = len(groups)
+			index[t.Group] = i
+			groups = append(groups, indexGroup{Name: t.Group})
+		}
+		groups[i].Tasks = append(groups[i].Tasks, t)
+	}
+	if len(groups[0].Tasks) == 0 {
+		groups = groups[1:]
+	}
+	return groups
+}
+
+// serveIndex lists the tasks, filtered and sorted by the form values described with matchTask
+// and sortTasks. Tasks pinned by the user are listed first.
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	runs, err := s.runner.LatestRuns()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	r.ParseForm()
+	query := url.Values{}
+	for _, k := range []string{"q", "tag", "status", "sort"} {
+		if v := r.Form.Get(k); v != "" {
+			query.Set(k, v)
+		}
+	}
+
+	type requestView struct {
+		*model.Request
+		TaskName string
+		Own      bool   // requested by the logged in user, who cannot approve it
+		Blocked  string // why the run must not start now
+	}
+
+	type view struct {
+		Title    string
+		Pinned   []indexTask
+		Groups   []indexGroup
+		Total    int // number of tasks before filtering
+		Tags     []string
+		Form     url.Values
+		Auth     bool        // whether the project has users
+		User     *model.User // logged in user, if any
+		Requests []requestView
+		Admin    bool // whether the user may view the audit log and declare freezes
+		Override bool // whether the user may start runs of blocked tasks
+		Freeze   *model.Freeze
+
+		Agents          []api.Agent
+		AgentsConnected int
+	}
+
+	v := view{
+		Title:  s.proj.Name,
+		Total:  len(s.proj.Tasks),
+		Form:   r.Form,
+		Auth:   len(s.proj.Users) > 0,
+		User:   s.user(r),
+		Freeze: s.runner.Frozen(),
+	}
+	v.Admin = !v.Auth || v.User != nil && v.User.Admin
+	v.Override = s.canOverride(r)
+	v.Agents = s.runner.Agents()
+	for _, a := range v.Agents {
+		if a.Connected {
+			v.AgentsConnected++
+		}
+	}
+	canPin := !v.Auth || v.User != nil
+
+	pinned := make(map[model.TaskID]bool)
+	if canPin {
+		for _, id := range s.runner.Favorites(s.userName(r)) {
+			pinned[id] = true
+		}
+	}
+
+	now := time.Now()
+	names := make(map[model.TaskID]string)
+	tags := make(map[string]bool)
+	var tasks []indexTask
+	for _, t := range s.proj.Tasks {
+		names[t.ID] = t.Name
+		for _, tag := range t.Tags {
+			tags[tag] = true
+		}
+
+		it := indexTask{
+			ID:          string(t.ID),
+			Name:        t.Name,
+			Description: t.Description,
+			Group:       t.Group,
+			Tags:        t.Tags,
+			Target:      model.FormatLabels(t.Target),
+			Confirm:     t.Confirm,
+			Approval:    t.Approval,
+			Blocked:     s.runner.Blocked(t, now),
+			Override:    v.Override,
+			Pinned:      pinned[t.ID],
+			CanPin:      canPin,
+			Query:       query.Encode(),
+		}
+		if run := runs[t.ID]; run != nil {
+			it.LastRun = indexRun{
+				ID:       string(run.ID),
+				TaskID:   string(t.ID),
+				Running:  s.runner.IsRunning(t.ID, run.ID),
+				Exists:   true,
+				ExitCode: run.ExitCode,
+				Canceled: run.Canceled,
+				Outcome:  run.Outcome,
+				Started:  run.Started,
+				Duration: s.duration(t.ID, run),
+				Summary:  run.Summary,
+				Results:  run.Results,
+			}
+		}
+		if matchTask(it, r.Form) {
+			tasks = append(tasks, it)
+		}
+	}
+
+	sortTasks(tasks, r.Form.Get("sort"))
+	var rest []indexTask
+	for _, t := range tasks {
+		if t.Pinned {
+			v.Pinned = append(v.Pinned, t)
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	v.Groups = groupTasks(rest)
+
+	for tag := range tags {
+		v.Tags = append(v.Tags, tag)
+	}
+	sort.Strings(v.Tags)
+
+	for _, req := range s.runner.Requests() {
+		own := v.User != nil && v.User.Name == req.User
+		task, _ := s.runner.Task(req.TaskID)
+		v.Requests = append(v.Requests, requestView{req, names[req.TaskID], own, s.runner.Blocked(task, now)})
+	}
+
+	s.renderTemplate(w, s.template.index, v)
+}
+
+// serveFavorite pins the task given by the form value t for the user or, if the form value
+// pin is empty, unpins it.
+func (s *Server) serveFavorite(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	if len(s.proj.Users) > 0 && s.user(r) == nil {
+		http.Error(w, "log in to pin tasks", http.StatusForbidden)
+		return
+	}
+
+	r.ParseForm()
+	tID := model.TaskID(r.Form.Get("t"))
+	if err := s.runner.SetFavorite(s.userName(r), tID, r.Form.Get("pin") != ""); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page := ""
+	if q := r.Form.Get("back"); q != "" {
+		page = "?" + q
+	}
+	http.Redirect(w, r, s.url(page)+"#task-"+string(tID), http.StatusSeeOther)
+}
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/search"
+	"github.com/ngrash/optask/internal/stdstreams"
+)
+
+const dateLayout = "2006-01-02" // format of HTML date inputs
+
+// parseQuery reads a search.Query from the form values q, re, t, from, to, stream and n.
+func parseQuery(form url.Values) (search.Query, error) {
+	q := search.Query{
+		Text:  form.Get("q"),
+		Regex: form.Get("re") != "",
+	}
+
+	for _, t := range form["t"] {
+		if t != "" {
+			q.Tasks = append(q.Tasks, model.TaskID(t))
+		}
+	}
+
+	var err error
+	if from := form.Get("from"); from != "" {
+		if q.From, err = time.ParseInLocation(dateLayout, from, time.Local); err != nil {
+			return q, fmt.Errorf("invalid from date %q", from)
+		}
+	}
+	if to := form.Get("to"); to != "" {
+		if q.To, err = time.ParseInLocation(dateLayout, to, time.Local); err != nil {
+			return q, fmt.Errorf("invalid to date %q", to)
+		}
+		q.To = q.To.AddDate(0, 0, 1) // include the whole day
+	}
+
+	switch form.Get("stream") {
+	case "":
+	case "out":
+		q.Stream = stdstreams.Out
+	case "err":
+		q.Stream = stdstreams.Err
+	default:
+		return q, fmt.Errorf("invalid stream %q", form.Get("stream"))
+	}
+
+	if n := form.Get("n"); n != "" {
+		if q.Limit, err = strconv.Atoi(n); err != nil || q.Limit < 1 {
+			return q, fmt.Errorf("invalid count %q", n)
+		}
+	}
+
+	return q, nil
+}
+
+func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	type taskView struct {
+		ID, Name string
+		Selected bool
+	}
+
+	type resultView struct {
+		search.Result
+		TaskName string
+		LineNo   int
+	}
+
+	type view struct {
+		Title   string
+		Form    url.Values
+		Tasks   []taskView
+		Results []resultView
+		Error   string
+		Done    bool // whether a search was performed
+	}
+
+	v := view{Title: s.proj.Name, Form: r.Form}
+
+	q, err := parseQuery(r.Form)
+	if err != nil {
+		v.Error = err.Error()
+	} else if q.Text != "" {
+		results, err := s.runner.Search(q)
+		if err != nil {
+			v.Error = err.Error()
+		}
+		for _, res := range results {
+			t, _ := s.runner.Task(res.TaskID)
+			v.Results = append(v.Results, resultView{res, t.Name, res.Index + 1})
+		}
+		v.Done = err == nil
+	}
+
+	selected := make(map[model.TaskID]bool)
+	for _, t := range q.Tasks {
+		selected[t] = true
+	}
+	for _, t := range s.proj.Tasks {
+		v.Tasks = append(v.Tasks, taskView{string(t.ID), t.Name, selected[t.ID]})
+	}
+
+	s.renderTemplate(w, s.template.search, v)
+}
+
+func (s *Server) serveAPISearch(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	q, err := parseQuery(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := s.runner.Search(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if results == nil {
+		results = []search.Result{}
+	}
+
+	writeJSON(w, results)
+}
@@ -0,0 +1,140 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/stats"
+)
+
+// size of the duration trend chart in SVG user units
+const (
+	chartWidth  = 600
+	chartHeight = 100
+)
+
+// bar is a run in the duration trend chart.
+type bar struct {
+	RunID      model.RunID
+	X, Y, W, H float64
+	Outcome    model.Outcome
+	Title      string
+}
+
+// trendBars lays out the trend as bars of equal width whose height is the duration relative
+// to the longest run.
+func trendBars(trend []stats.Point) []bar {
+	var max time.Duration
+	for _, p := range trend {
+		if p.Duration > max {
+			max = p.Duration
+		}
+	}
+
+	bars := make([]bar, len(trend))
+	w := float64(chartWidth) / float64(len(trend))
+	for i, p := range trend {
+		h := chartHeight * 0.02 // keep instant runs visible
+		if max > 0 {
+			h += chartHeight * 0.98 * float64(p.Duration) / float64(max)
+		}
+		title := fmt.Sprintf("Run %v, %v, %v, %v", p.RunID, p.Started.Format("2006-01-02 15:04:05"), roundDuration(p.Duration), p.Outcome)
+		bars[i] = bar{p.RunID, float64(i) * w, chartHeight - h, w * 0.8, h, p.Outcome, title}
+	}
+	return bars
+}
+
+// roundDuration rounds d to a precision suitable for display.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second)
+	case d >= time.Second:
+		return d.Round(100 * time.Millisecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+func percent(f float64) string {
+	return fmt.Sprintf("%.0f%%", f*100)
+}
+
+func (s *Server) serveStats(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	tID := model.TaskID(r.Form.Get("t"))
+
+	task, err := s.runner.Task(tID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	st, err := s.runner.Stats(tID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	type rateView struct {
+		Window    string
+		Runs      int
+		Succeeded int
+		Warnings  int
+		Errors    int
+		Rate      string
+	}
+
+	type view struct {
+		Title            string
+		Task             model.Task
+		Stats            *stats.Stats
+		Rates            []rateView
+		Mean, P50, P95   time.Duration
+		SinceLastSuccess time.Duration // zero if no run succeeded
+		Flakiness        string
+		Bars             []bar
+		ChartWidth       int
+		ChartHeight      int
+	}
+
+	v := view{
+		Title:       s.proj.Name,
+		Task:        task,
+		Stats:       st,
+		Mean:        roundDuration(st.Mean),
+		P50:         roundDuration(st.P50),
+		P95:         roundDuration(st.P95),
+		Flakiness:   percent(st.Flakiness),
+		Bars:        trendBars(st.Trend),
+		ChartWidth:  chartWidth,
+		ChartHeight: chartHeight,
+	}
+	for _, rate := range st.Rates {
+		v.Rates = append(v.Rates, rateView{rate.Window, rate.Runs, rate.Succeeded, rate.Warnings, rate.Errors, percent(rate.Rate)})
+	}
+	if !st.LastSuccess.IsZero() {
+		v.SinceLastSuccess = time.Since(st.LastSuccess).Truncate(time.Second)
+	}
+
+	s.renderTemplate(w, s.template.stats, v)
+}
+
+func (s *Server) serveAPIStats(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	tID := model.TaskID(r.Form.Get("t"))
+
+	if _, err := s.runner.Task(tID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	st, err := s.runner.Stats(tID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, st)
+}
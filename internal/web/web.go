@@ -2,12 +2,13 @@ package web
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -17,54 +18,103 @@ import (
 	"github.com/ngrash/optask/internal/stdstreams"
 )
 
-const TemplatePath = "web/tmpl"
-const ReloadTemplates = true
+// Options configure a Server.
+type Options struct {
+	BasePath    string // URL path the server is mounted at, e.g. /ops/
+	Dev         bool   // read assets from the web directory and reload templates on every request
+	OverrideDir string // directory with tmpl and static files replacing the built-in ones
+}
 
 // Context is passed to each web handler function
 type Server struct {
 	proj     *model.Project
 	runner   *runner.Service
+	opts     Options
+	assets   fs.FS
 	mux      *http.ServeMux
+	handler  http.Handler // mux without BasePath
 	template struct {
-		index, show, history *template.Template
+		index, show, history, search, stats, compare, login, audit, agents *template.Template
+		snapshot                                                           *template.Template // standalone page without root.tmpl
 	}
 }
 
-func NewServer(p *model.Project, r *runner.Service) (*Server, error) {
-	s := &Server{proj: p, runner: r, mux: http.NewServeMux()}
+func NewServer(p *model.Project, r *runner.Service, opts Options) (*Server, error) {
+	opts.BasePath = "/" + strings.Trim(opts.BasePath, "/") + "/"
+	if opts.BasePath == "//" {
+		opts.BasePath = "/"
+	}
+
+	s := &Server{proj: p, runner: r, opts: opts, assets: assetFS(opts), mux: http.NewServeMux()}
+	s.handler = s.mux
+	if opts.BasePath != "/" {
+		outer := http.NewServeMux() // redirects /base to /base/
+		outer.Handle(opts.BasePath, http.StripPrefix(strings.TrimSuffix(opts.BasePath, "/"), s.mux))
+		s.handler = outer
+	}
 	if err := s.loadTemplates(); err != nil {
 		return nil, err
 	}
 
-	s.mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
-	s.mux.HandleFunc("/", s.serveIndex)
-	s.mux.HandleFunc("/exec", s.serveExec)
-	s.mux.HandleFunc("/status", s.serveStatus)
-	s.mux.HandleFunc("/show", s.serveShow)
-	s.mux.HandleFunc("/history", s.serveHistory)
-	s.mux.HandleFunc("/stdstreams", s.serveStdstreams)
+	static, err := fs.Sub(s.assets, "static")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+	s.mux.HandleFunc("/", s.requireLogin(s.serveIndex))
+	s.mux.HandleFunc("/exec", s.requireLogin(s.serveExec))
+	s.mux.HandleFunc("/status", s.requireLogin(s.serveStatus))
+	s.mux.HandleFunc("/show", s.requireLogin(s.serveShow))
+	s.mux.HandleFunc("/history", s.requireLogin(s.serveHistory))
+	s.mux.HandleFunc("/stdstreams", s.requireLogin(s.serveStdstreams))
+	s.mux.HandleFunc("/cancel", s.requireLogin(s.serveCancel))
+	s.mux.HandleFunc("/approve", s.requireLogin(s.serveApprove))
+	s.mux.HandleFunc("/reject", s.requireLogin(s.serveReject))
+	s.mux.HandleFunc("/freeze", s.requireLogin(s.serveFreeze))
+	s.mux.HandleFunc("/favorite", s.requireLogin(s.serveFavorite))
+	s.mux.HandleFunc("/search", s.requireLogin(s.serveSearch))
+	s.mux.HandleFunc("/cast", s.requireLogin(s.serveCast))
+	s.mux.HandleFunc("/stdin", s.requireLogin(s.serveStdin))
+	s.mux.HandleFunc("/download", s.requireLogin(s.serveDownload))
+	s.mux.HandleFunc("/artifact", s.requireLogin(s.serveArtifact))
+	s.mux.HandleFunc("/export", s.requireLogin(s.serveExport))
+	s.mux.HandleFunc("/stats", s.requireLogin(s.serveStats))
+	s.mux.HandleFunc("/compare", s.requireLogin(s.serveCompare))
+	s.mux.HandleFunc("/login", s.serveLogin)
+	s.mux.HandleFunc("/logout", s.serveLogout)
+	s.mux.HandleFunc("/audit", s.requireLogin(s.serveAudit))
+	s.mux.HandleFunc("/agents", s.requireLogin(s.serveAgents))
+	s.handleAPI()
 
 	return s, nil
 }
 
-func exist(path string) bool {
-	_, err := os.Stat(path)
-	return !os.IsNotExist(err)
+func (s *Server) exist(path string) bool {
+	_, err := fs.Stat(s.assets, path)
+	return !errors.Is(err, fs.ErrNotExist)
 }
 
 func (s *Server) loadTemplates() error {
-	root := filepath.Join(TemplatePath, "root.tmpl")
+	root := path.Join("tmpl", "root.tmpl")
+
+	common := path.Join("tmpl", "common.tmpl")
+	hasCommon := s.exist(common)
 
-	common := filepath.Join(TemplatePath, "common.tmpl")
-	hasCommon := exist(common)
+	funcs := template.FuncMap{
+		"base": func() string { return s.opts.BasePath },
+		"lineHTML": func(l stdstreams.Line) template.HTML {
+			return template.HTML(l.HTML()) // escaped by Line.HTML
+		},
+		"bytes": formatBytes,
+	}
 
 	parse := func(name string) (*template.Template, error) {
-		path := filepath.Join(TemplatePath, name)
-		files := []string{root, path}
+		files := []string{root, path.Join("tmpl", name)}
 		if hasCommon {
 			files = append(files, common)
 		}
-		return template.ParseFiles(files...)
+		return template.New("root.tmpl").Funcs(funcs).ParseFS(s.assets, files...)
 	}
 
 	var err error
@@ -80,6 +130,34 @@ func (s *Server) loadTemplates() error {
 	if err != nil {
 		return err
 	}
+	s.template.search, err = parse("search.tmpl")
+	if err != nil {
+		return err
+	}
+	s.template.stats, err = parse("stats.tmpl")
+	if err != nil {
+		return err
+	}
+	s.template.compare, err = parse("compare.tmpl")
+	if err != nil {
+		return err
+	}
+	s.template.login, err = parse("login.tmpl")
+	if err != nil {
+		return err
+	}
+	s.template.audit, err = parse("audit.tmpl")
+	if err != nil {
+		return err
+	}
+	s.template.agents, err = parse("agents.tmpl")
+	if err != nil {
+		return err
+	}
+	s.template.snapshot, err = template.New("snapshot.tmpl").Funcs(funcs).ParseFS(s.assets, path.Join("tmpl", "snapshot.tmpl"))
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -96,57 +174,19 @@ func handleErrorMaybe(w http.ResponseWriter, err error) {
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if ReloadTemplates {
-		s.loadTemplates()
-	}
-
-	s.mux.ServeHTTP(w, r)
-}
-
-func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
-	runs, err := s.runner.LatestRuns()
-	if err != nil {
-		log.Panic(err)
-	}
-
-	type runView struct {
-		ID       string
-		TaskID   string
-		ExitCode int
-		Running  bool
-		Duration time.Duration
-		Exists   bool
-	}
-
-	type taskView struct {
-		ID, Name string
-		LastRun  runView
-	}
-
-	type view struct {
-		Title string
-		Tasks []taskView
-	}
-
-	tasks := make([]taskView, len(s.proj.Tasks))
-	for i, t := range s.proj.Tasks {
-		tasks[i] = taskView{ID: string(t.ID), Name: t.Name}
-		r := runs[t.ID]
-		if r != nil {
-			tasks[i].LastRun = runView{
-				ID:       string(r.ID),
-				TaskID:   string(t.ID),
-				Running:  s.runner.IsRunning(t.ID, r.ID),
-				Exists:   true,
-				ExitCode: r.ExitCode,
-				Duration: s.duration(t.ID, r),
-			}
+	if s.opts.Dev {
+		if err := s.loadTemplates(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 	}
 
-	v := view{s.proj.Name, tasks}
+	s.handler.ServeHTTP(w, r)
+}
 
-	s.renderTemplate(w, s.template.index, v)
+// url returns the absolute path of a page below BasePath.
+func (s *Server) url(page string) string {
+	return s.opts.BasePath + page
 }
 
 func (s *Server) duration(tID model.TaskID, r *model.Run) time.Duration {
@@ -163,18 +203,31 @@ func (s *Server) serveShow(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 
 	type viewModel struct {
-		Title     string
-		Name      string
-		CmdLine   string
-		Lines     []stdstreams.Line
-		ExitCode  int
-		Duration  time.Duration
-		Skip      int
-		Running   bool
-		ID        string
-		TaskID    string
-		Started   time.Time
-		Completed time.Time
+		Title       string
+		Name        string
+		CmdLine     string
+		Lines       []stdstreams.Line
+		ExitCode    int
+		Duration    time.Duration
+		Skip        int
+		Running     bool
+		Canceled    bool
+		Outcome     model.Outcome
+		Error       string
+		Agent       string
+		ID          string
+		TaskID      string
+		Started     time.Time
+		Completed   time.Time
+		Recorded    bool // whether a terminal recording is available
+		Interactive bool // whether the run accepts input
+		Artifacts   []model.Artifact
+		Summary     string
+		Results     []model.Result
+		Override    string
+		OverrideBy  string
+		MaxRSS      int64
+		CPUTime     time.Duration
 	}
 
 	tID := model.TaskID(r.Form.Get("t"))
@@ -201,18 +254,31 @@ func (s *Server) serveShow(w http.ResponseWriter, r *http.Request) {
 	lines := streams.Lines()
 
 	v := &viewModel{
-		Title:     s.proj.Name,
-		Name:      task.Name,
-		CmdLine:   cmdLine,
-		Lines:     lines,
-		Skip:      len(lines),
-		Running:   isRunning,
-		Duration:  s.duration(tID, run),
-		ExitCode:  run.ExitCode,
-		ID:        string(rID),
-		TaskID:    string(tID),
-		Started:   run.Started,
-		Completed: run.Completed,
+		Title:       s.proj.Name,
+		Name:        task.Name,
+		CmdLine:     cmdLine,
+		Lines:       lines,
+		Skip:        len(lines),
+		Running:     isRunning,
+		Duration:    s.duration(tID, run),
+		ExitCode:    run.ExitCode,
+		Canceled:    run.Canceled,
+		Outcome:     run.Outcome,
+		Error:       run.Error,
+		Agent:       run.Agent,
+		ID:          string(rID),
+		TaskID:      string(tID),
+		Started:     run.Started,
+		Completed:   run.Completed,
+		Recorded:    streams.Recording() != nil,
+		Interactive: task.Interactive,
+		Artifacts:   run.Artifacts,
+		Summary:     run.Summary,
+		Results:     run.Results,
+		Override:    run.Override,
+		OverrideBy:  run.OverrideBy,
+		MaxRSS:      run.MaxRSS,
+		CPUTime:     run.CPUTime.Round(time.Millisecond),
 	}
 
 	s.renderTemplate(w, s.template.show, v)
@@ -230,6 +296,9 @@ func (s *Server) serveStatus(w http.ResponseWriter, r *http.Request) {
 
 	type data struct {
 		Running   bool
+		Canceled  bool
+		Outcome   model.Outcome
+		Error     string
 		Started   time.Time
 		Completed time.Time
 		ExitCode  int
@@ -237,6 +306,9 @@ func (s *Server) serveStatus(w http.ResponseWriter, r *http.Request) {
 
 	d := data{
 		Running:   s.runner.IsRunning(tID, rID),
+		Canceled:  run.Canceled,
+		Outcome:   run.Outcome,
+		Error:     run.Error,
 		Started:   run.Started,
 		Completed: run.Completed,
 		ExitCode:  run.ExitCode,
@@ -248,12 +320,67 @@ func (s *Server) serveStatus(w http.ResponseWriter, r *http.Request) {
 func (s *Server) serveExec(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	tID := r.Form.Get("t")
-	rID, err := s.runner.Exec(model.TaskID(tID))
+	task, err := s.runner.Task(model.TaskID(tID))
 	if err != nil {
-		log.Panic(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if task.Confirm && r.Form.Get("confirm") != tID {
+		http.Error(w, "type the task ID to confirm the run", http.StatusBadRequest)
+		return
 	}
+	if task.Approval {
+		if _, ok := s.requestExec(w, r, task.ID, nil); ok {
+			http.Redirect(w, r, s.url(""), http.StatusSeeOther)
+		}
+		return
+	}
+
+	rID, detail, ok := s.exec(w, r, task, nil)
+	if !ok {
+		return
+	}
+	s.audit(r, model.AuditExec, model.TaskID(tID), rID, detail)
 
-	http.Redirect(w, r, "/show?t="+tID+"&r="+string(rID), http.StatusSeeOther)
+	http.Redirect(w, r, s.url("show?t="+tID+"&r="+string(rID)), http.StatusSeeOther)
+}
+
+func (s *Server) serveStdin(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	r.ParseForm()
+	tID := r.Form.Get("t")
+	rID := r.Form.Get("r")
+	if err := s.runner.Input(model.TaskID(tID), model.RunID(rID), r.Form.Get("line")); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.audit(r, model.AuditInput, model.TaskID(tID), model.RunID(rID), "")
+
+	if r.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, s.url("show?t="+tID+"&r="+rID), http.StatusSeeOther)
+}
+
+func (s *Server) serveCancel(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	r.ParseForm()
+	tID := r.Form.Get("t")
+	rID := r.Form.Get("r")
+	if err := s.runner.Cancel(model.TaskID(tID), model.RunID(rID)); err != nil {
+		log.Printf("canceling run: %v", err) // e.g. the run completed in the meantime
+	} else {
+		s.audit(r, model.AuditCancel, model.TaskID(tID), model.RunID(rID), "")
+	}
+
+	http.Redirect(w, r, s.url("show?t="+tID+"&r="+rID), http.StatusSeeOther)
 }
 
 func (s *Server) serveHistory(w http.ResponseWriter, r *http.Request) {
@@ -271,8 +398,12 @@ func (s *Server) serveHistory(w http.ResponseWriter, r *http.Request) {
 		ID       string
 		TaskID   string
 		Running  bool
+		Canceled bool
+		Outcome  model.Outcome
 		ExitCode int
 		Duration time.Duration
+		Summary  string
+		Results  []model.Result
 	}
 
 	type taskView struct {
@@ -292,8 +423,12 @@ func (s *Server) serveHistory(w http.ResponseWriter, r *http.Request) {
 			ID:       string(r.ID),
 			TaskID:   string(tID),
 			ExitCode: r.ExitCode,
+			Canceled: r.Canceled,
+			Outcome:  r.Outcome,
 			Running:  s.runner.IsRunning(tID, r.ID),
 			Duration: s.duration(tID, r),
+			Summary:  r.Summary,
+			Results:  r.Results,
 		}
 	}
 
@@ -340,3 +475,26 @@ func (s *Server) serveStdstreams(w http.ResponseWriter, r *http.Request) {
 	buf := bytes.NewBuffer(json)
 	buf.WriteTo(w)
 }
+
+func (s *Server) serveCast(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	tID := model.TaskID(r.Form.Get("t"))
+	rID := model.RunID(r.Form.Get("r"))
+
+	streams, err := s.runner.StdStreams(tID, rID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	rec := streams.Recording()
+	if rec == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := fmt.Sprintf("%v-%v.cast", tID, rID)
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(name))
+	handleErrorMaybe(w, rec.WriteCast(w))
+}
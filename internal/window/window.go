@@ -0,0 +1,179 @@
+// Package window decides whether runs may start at a given time, see model.Task.Windows and
+// model.Project.TagWindows.
+package window
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+var days = map[string]time.Weekday{
+	"Sun": time.Sunday,
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
+}
+
+// ParseDay parses an abbreviated weekday like Mon.
+func ParseDay(s string) (time.Weekday, error) {
+	d, ok := days[s]
+	if !ok {
+		return 0, fmt.Errorf("invalid day %q, expected one of Mon, Tue, Wed, Thu, Fri, Sat, Sun", s)
+	}
+	return d, nil
+}
+
+// ParseClock parses a time of day as HH:MM and returns the minutes since midnight.
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil || len(s) != 5 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Window is a compiled model.Window.
+type Window struct {
+	deny     bool
+	days     [7]bool
+	from, to int // minutes since midnight
+	loc      *time.Location
+	desc     string
+}
+
+// Compile compiles w.
+func Compile(w model.Window) (*Window, error) {
+	c := &Window{deny: w.Deny, loc: time.Local}
+
+	if len(w.Days) == 0 {
+		c.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, s := range w.Days {
+		d, err := ParseDay(s)
+		if err != nil {
+			return nil, err
+		}
+		c.days[d] = true
+	}
+
+	var err error
+	if c.from, err = ParseClock(w.From); err != nil {
+		return nil, err
+	}
+	if c.to, err = ParseClock(w.To); err != nil {
+		return nil, err
+	}
+	if w.TimeZone != "" {
+		if c.loc, err = time.LoadLocation(w.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+
+	c.desc = w.From + "-" + w.To
+	if len(w.Days) > 0 {
+		c.desc = strings.Join(w.Days, ",") + " " + c.desc
+	}
+	if w.TimeZone != "" {
+		c.desc += " " + w.TimeZone
+	}
+	return c, nil
+}
+
+// Contains reports whether t is in the window. Windows with To not after From end on the next
+// day, so a window from 22:00 to 06:00 on Fri contains Saturday 05:00. A window with From equal
+// to To lasts a whole day.
+func (w *Window) Contains(t time.Time) bool {
+	t = t.In(w.loc)
+	m := t.Hour()*60 + t.Minute()
+	today := w.days[t.Weekday()]
+	if w.from < w.to {
+		return today && w.from <= m && m < w.to
+	}
+	yesterday := w.days[(t.Weekday()+6)%7]
+	return today && m >= w.from || yesterday && m < w.to
+}
+
+func (w *Window) String() string {
+	return w.desc
+}
+
+// Set are the compiled windows of a task.
+type Set []*Window
+
+// CompileAll compiles ws.
+func CompileAll(ws []model.Window) (Set, error) {
+	set := make(Set, len(ws))
+	for i, w := range ws {
+		c, err := Compile(w)
+		if err != nil {
+			return nil, err
+		}
+		set[i] = c
+	}
+	return set, nil
+}
+
+// Blocked returns why runs must not start at t, or an empty string if they may.
+func (s Set) Blocked(t time.Time) string {
+	var allow []string
+	inAllow := false
+	for _, w := range s {
+		switch {
+		case w.deny && w.Contains(t):
+			return "in blackout window " + w.String()
+		case !w.deny:
+			allow = append(allow, w.String())
+			inAllow = inAllow || w.Contains(t)
+		}
+	}
+	if len(allow) > 0 && !inAllow {
+		return "outside maintenance windows " + strings.Join(allow, ", ")
+	}
+	return ""
+}
+
+// Project are the compiled windows of the tasks and tags of a project.
+type Project struct {
+	tasks map[model.TaskID]Set
+	tags  map[string]Set
+}
+
+// CompileProject compiles the windows of the tasks of p and p.TagWindows.
+func CompileProject(p *model.Project) (*Project, error) {
+	c := &Project{tasks: make(map[model.TaskID]Set), tags: make(map[string]Set)}
+	for _, task := range p.Tasks {
+		set, err := CompileAll(task.Windows)
+		if err != nil {
+			return nil, fmt.Errorf("task %v: %w", task.ID, err)
+		}
+		c.tasks[task.ID] = set
+	}
+	for tag, ws := range p.TagWindows {
+		set, err := CompileAll(ws)
+		if err != nil {
+			return nil, fmt.Errorf("tag %v: %w", tag, err)
+		}
+		c.tags[tag] = set
+	}
+	return c, nil
+}
+
+// TaskBlocked returns why runs of task must not start at t, or an empty string if they may.
+// The windows of the task and of each of its tags must all allow the run.
+func (p *Project) TaskBlocked(task model.Task, t time.Time) string {
+	if reason := p.tasks[task.ID].Blocked(t); reason != "" {
+		return reason
+	}
+	for _, tag := range task.Tags {
+		if reason := p.tags[tag].Blocked(t); reason != "" {
+			return "tag " + tag + ": " + reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package window
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ngrash/optask/internal/model"
+)
+
+func TestContains(t *testing.T) {
+	// 2024-01-05 is a Friday.
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, 1, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		window   model.Window
+		t        time.Time
+		expected bool
+	}{
+		{"Inside", model.Window{From: "09:00", To: "17:00"}, at(5, 9, 0), true},
+		{"EndExclusive", model.Window{From: "09:00", To: "17:00"}, at(5, 17, 0), false},
+		{"OtherDay", model.Window{Days: []string{"Mon"}, From: "09:00", To: "17:00"}, at(5, 10, 0), false},
+		{"Overnight", model.Window{Days: []string{"Fri"}, From: "22:00", To: "06:00"}, at(6, 5, 59), true},
+		{"OvernightStart", model.Window{Days: []string{"Fri"}, From: "22:00", To: "06:00"}, at(5, 23, 0), true},
+		{"OvernightOtherDay", model.Window{Days: []string{"Fri"}, From: "22:00", To: "06:00"}, at(5, 5, 0), false},
+		{"WholeDay", model.Window{Days: []string{"Sat"}, From: "00:00", To: "00:00"}, at(6, 23, 59), true},
+		{"TimeZone", model.Window{From: "09:00", To: "17:00", TimeZone: "America/New_York"}, at(5, 13, 0), false},
+		{"TimeZoneInside", model.Window{From: "09:00", To: "17:00", TimeZone: "America/New_York"}, at(5, 15, 0), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, err := Compile(test.window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Contains(test.t); got != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestBlocked(t *testing.T) {
+	set, err := CompileAll([]model.Window{
+		{Days: []string{"Sat", "Sun"}, From: "00:00", To: "00:00"},
+		{From: "22:00", To: "06:00"},
+		{Deny: true, Days: []string{"Sun"}, From: "03:00", To: "04:00"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		t       string
+		blocked string
+	}{
+		{"2024-01-05 12:00", "outside maintenance windows Sat,Sun 00:00-00:00, 22:00-06:00"},
+		{"2024-01-05 23:00", ""},
+		{"2024-01-06 12:00", ""},
+		{"2024-01-07 03:30", "in blackout window Sun 03:00-04:00"},
+	}
+
+	for _, test := range tests {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", test.t, time.Local)
+		if got := set.Blocked(tm); got != test.blocked {
+			t.Errorf("%v: expected %q, got %q", test.t, test.blocked, got)
+		}
+	}
+}
+
+func TestTaskBlocked(t *testing.T) {
+	task := model.Task{
+		ID:      "t",
+		Tags:    []string{"db", "nightly"},
+		Windows: []model.Window{{Deny: true, From: "12:00", To: "13:00"}},
+	}
+	p, err := CompileProject(&model.Project{
+		Tasks: []model.Task{task},
+		TagWindows: map[string][]model.Window{
+			"db":  {{From: "22:00", To: "06:00"}},
+			"web": {{Deny: true, From: "00:00", To: "00:00"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		t       string
+		blocked string
+	}{
+		{"2024-01-05 23:00", ""},
+		{"2024-01-05 12:30", "in blackout window 12:00-13:00"},
+		{"2024-01-05 14:00", "tag db: outside maintenance windows 22:00-06:00"},
+	}
+
+	for _, test := range tests {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", test.t, time.Local)
+		if got := p.TaskBlocked(task, tm); got != test.blocked {
+			t.Errorf("%v: expected %q, got %q", test.t, test.blocked, got)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	for _, s := range []string{"9:00", "24:00", "12:60", "noon"} {
+		if _, err := ParseClock(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+	if m, err := ParseClock("23:59"); err != nil || m != 23*60+59 {
+		t.Errorf("23:59: got %v, %v", m, err)
+	}
+}
@@ -1,26 +1,90 @@
 package main
 
 import (
-	"log"
-	"net/http"
+	"fmt"
+	"os"
+	"strings"
 
-	"github.com/ngrash/optask/internal/config"
 	"github.com/ngrash/optask/internal/runner"
-	"github.com/ngrash/optask/internal/web"
 )
 
-func main() {
-	project, err := config.Read("config.json")
-	if err != nil {
-		log.Fatalf("Error reading config: %v", err)
-	}
+const usage = `Usage: optask [command] [arguments]
+
+Commands:
+  serve      start the web server (default)
+  validate   check configuration files for errors
+  run        start a run of a task
+  tail       print the output of a run
+  history    list the runs of a task
+  status     list all tasks with their latest run
+  cancel     stop a running run
+  requests   list runs waiting for approval
+  approve    start a run requested by another user
+  reject     discard a run waiting for approval
+  freeze     block all runs, e.g. during a release
+  unfreeze   lift a freeze
+  backup     download a copy of the run database from a server
+  restore    replace the run database with a backup
+  import     add runs from a portable backup to the run database
+  migrate    update the schema of the run database
+  agent      run tasks assigned by a server
+  agents     list the agents of a server
 
-	runner := runner.NewService(project)
+Run 'optask <command> -h' for details.
+`
 
-	s, err := web.NewServer(project, runner)
-	if err != nil {
-		log.Fatalf("initializing http server: %v", err)
+func main() {
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
 
-	log.Fatal(http.ListenAndServe(":8080", s))
+	switch cmd {
+	case "serve":
+		serve(args)
+	case "validate":
+		os.Exit(validate(args))
+	case "run":
+		os.Exit(runCmd(args))
+	case "tail":
+		os.Exit(tailCmd(args))
+	case "history":
+		os.Exit(historyCmd(args))
+	case "status":
+		os.Exit(statusCmd(args))
+	case "cancel":
+		os.Exit(cancelCmd(args))
+	case "requests":
+		os.Exit(requestsCmd(args))
+	case "approve":
+		os.Exit(approveCmd(args))
+	case "reject":
+		os.Exit(rejectCmd(args))
+	case "freeze":
+		os.Exit(freezeCmd(args))
+	case "unfreeze":
+		os.Exit(unfreezeCmd(args))
+	case "backup":
+		os.Exit(backupCmd(args))
+	case "restore":
+		os.Exit(restoreCmd(args))
+	case "import":
+		os.Exit(importCmd(args))
+	case "migrate":
+		os.Exit(migrateCmd(args))
+	case "agent":
+		os.Exit(agentCmd(args))
+	case "agents":
+		os.Exit(agentsCmd(args))
+	case runner.LimitCommand: // internal, see runner.ExecLimited
+		if err := runner.ExecLimited(args); err != nil {
+			fmt.Fprintf(os.Stderr, "optask: %v\n", err)
+			os.Exit(127)
+		}
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "optask: unknown command %q\n\n%v", cmd, usage)
+		os.Exit(2)
+	}
 }
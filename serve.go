@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngrash/optask/internal/config"
+	"github.com/ngrash/optask/internal/db"
+	"github.com/ngrash/optask/internal/model"
+	"github.com/ngrash/optask/internal/runner"
+	"github.com/ngrash/optask/internal/web"
+)
+
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", envOr("OPTASK_ADDR", ":8080"), "listen address, or unix:<path> for a Unix socket (env OPTASK_ADDR)")
+	configPath := fs.String("config", envOr("OPTASK_CONFIG", "config.json"), "project configuration file (env OPTASK_CONFIG)")
+	dataDir := fs.String("data", envOr("OPTASK_DATA", "data"), "directory for the run database (env OPTASK_DATA)")
+	storage := fs.String("storage", envOr("OPTASK_STORAGE", db.Bolt), "storage backend of the run database, bolt or sqlite (env OPTASK_STORAGE)")
+	dev := fs.Bool("dev", os.Getenv("OPTASK_DEV") != "", "serve assets from ./web and reload templates on every request (env OPTASK_DEV)")
+	overrides := fs.String("overrides", os.Getenv("OPTASK_OVERRIDES"), "directory with tmpl and static files replacing the built-in ones (env OPTASK_OVERRIDES)")
+	basePath := fs.String("base-path", envOr("OPTASK_BASE_PATH", "/"), "URL path to serve at, e.g. /ops/ behind a reverse proxy (env OPTASK_BASE_PATH)")
+	certFile := fs.String("tls-cert", os.Getenv("OPTASK_TLS_CERT"), "TLS certificate file, reloaded when changed (env OPTASK_TLS_CERT)")
+	keyFile := fs.String("tls-key", os.Getenv("OPTASK_TLS_KEY"), "TLS key file, reloaded when changed (env OPTASK_TLS_KEY)")
+	cgroup := fs.String("cgroup", os.Getenv("OPTASK_CGROUP"), "cgroup v2 directory delegated to optask for Memory and CPUQuota limits (env OPTASK_CGROUP)")
+	fs.Parse(args)
+
+	project, err := config.Read(*configPath)
+	if err != nil {
+		log.Fatalf("Error reading config:\n%v", err)
+	}
+
+	runner := runner.NewService(project, *dataDir, *storage)
+	if *cgroup != "" {
+		if err := runner.UseCgroup(*cgroup); err != nil {
+			log.Fatalf("using cgroup: %v", err)
+		}
+	}
+	start := &model.AuditEntry{RemoteAddr: "local", Action: model.AuditStart, Detail: *configPath}
+	if err := runner.RecordAudit(start); err != nil {
+		log.Fatalf("recording start in audit log: %v", err)
+	}
+
+	s, err := web.NewServer(project, runner, web.Options{
+		BasePath:    *basePath,
+		Dev:         *dev,
+		OverrideDir: *overrides,
+	})
+	if err != nil {
+		log.Fatalf("initializing http server: %v", err)
+	}
+
+	l, err := listen(*addr)
+	if err != nil {
+		log.Fatalf("listening on %v: %v", *addr, err)
+	}
+
+	srv := &http.Server{Handler: s}
+	if *certFile == "" && *keyFile == "" {
+		log.Fatal(srv.Serve(l))
+	}
+
+	certs, err := newCertReloader(*certFile, *keyFile)
+	if err != nil {
+		log.Fatalf("loading TLS certificate: %v", err)
+	}
+
+	srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	log.Fatal(srv.ServeTLS(l, "", ""))
+}
+
+// listen listens on a TCP address or, if addr starts with unix:, on a Unix socket.
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, "unix:") {
+		return net.Listen("tcp", addr)
+	}
+
+	path := strings.TrimPrefix(addr, "unix:")
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err // stale socket of a previous process
+	}
+	return net.Listen("unix", path)
+}
+
+const certCheckInterval = 10 * time.Second // minimum time between checks for changed certificates
+
+// certReloader serves a TLS certificate and reloads it when the certificate or key file changes.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // latest modification time of certFile and keyFile
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both -tls-cert and -tls-key are required")
+	}
+
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) > certCheckInterval {
+		if err := c.reload(); err != nil {
+			log.Printf("reloading TLS certificate: %v", err) // keep serving the old one
+		}
+	}
+
+	return c.cert, nil
+}
+
+func (c *certReloader) reload() error {
+	c.checked = time.Now()
+
+	modTime, err := latestModTime(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	if !modTime.After(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	if c.cert != nil {
+		log.Printf("reloaded TLS certificate from %v", c.certFile)
+	}
+	c.cert, c.modTime = &cert, modTime
+	return nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ngrash/optask/internal/config"
+)
+
+// validate checks the given configuration files and prints one line per problem.
+// It returns a non-zero exit code if any file is invalid, which makes it suitable for
+// pre-commit hooks.
+func validate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: optask validate [file ...]")
+	}
+	fs.Parse(args)
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{"config.json"}
+	}
+
+	exit := 0
+	for _, f := range files {
+		if _, err := config.Read(f); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit = 1
+		}
+	}
+
+	return exit
+}
@@ -3,6 +3,7 @@
 	var rID = sink.dataset.rid;
 	var tID = sink.dataset.tid;
 	var skip = sink.dataset.skip;
+	var base = sink.dataset.base;
 
 	if(skip == 0) {
 		sink.innerHTML = "";
@@ -25,15 +26,15 @@
 	}
 
 	function fetchStdStreams() {
-		var url = "stdstreams?t=" + tID + "&r=" + rID + "&s=" + skip;
+		var url = base + "stdstreams?t=" + tID + "&r=" + rID + "&s=" + skip;
 		get(url, function(req) {
 			var json = JSON.parse(req.responseText);
 			for(var i = 0; i < json.length; i++) {
-				skip++;
 				var line = json[i];
 				var elem = document.createElement("div");
-				elem.innerHTML = line.Text;
-				elem.className = line.Stream == 1 ? "" : "stderr";
+				elem.id = "L" + skip++;
+				elem.innerHTML = line.HTML; // escaped by the server
+				elem.className = "stdstream-" + line.Stream + "-line";
 				sink.appendChild(elem);
 			}
 
@@ -41,6 +42,12 @@
 				setTimeout(fetchStdStreams, 200);
 			} else {
 				document.getElementById("running-indicator").remove();
+				["cancel", "stdin"].forEach(function(id) {
+					var elem = document.getElementById(id);
+					if(elem != null) {
+						elem.remove();
+					}
+				});
 				refreshStatus();
 			}
 		});
@@ -53,11 +60,29 @@
 			return;
 		}
 
-		var url = "status?t=" + tID + "&r=" + rID;
+		var url = base + "status?t=" + tID + "&r=" + rID;
 		get(url, function(req) {
 			statusElem.innerHTML = req.responseText;
 		});
 	}
 
+	// send input without reloading the page
+	var stdin = document.getElementById("stdin");
+	if(stdin != null) {
+		stdin.onsubmit = function(e) {
+			e.preventDefault();
+			var r = new XMLHttpRequest();
+			r.open("POST", stdin.action);
+			r.setRequestHeader("X-Requested-With", "XMLHttpRequest");
+			r.onreadystatechange = function() {
+				if(r.readyState == 4 && r.status >= 300) {
+					console.error("POST " + stdin.action + " with status " + r.status);
+				}
+			};
+			r.send(new URLSearchParams(new FormData(stdin)));
+			stdin.elements.line.value = "";
+		};
+	}
+
 	fetchStdStreams();
 })();
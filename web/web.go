@@ -0,0 +1,9 @@
+// Package web contains the templates and static assets of the web interface.
+package web
+
+import "embed"
+
+// FS contains the tmpl and static directories.
+//
+//go:embed tmpl static
+var FS embed.FS